@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var outTar = flag.String("out-tar", "", "write every template into this gzip tar archive as srcpkgs/<pkg>/template instead of the output directory")
+
+// templateFile is where a single template is written, either a file in the
+// output directory or an entry of the -out-tar archive.
+type templateFile interface {
+	io.Writer
+	Close() error
+	Abort()
+}
+
+// tarArchive collects templates from concurrent workers into one gzip tar.
+// Entries are held until Close writes them sorted by path, so the archive
+// doesn't depend on the order workers finish in.
+type tarArchive struct {
+	mu      sync.Mutex
+	f       *atomicFile
+	entries map[string][]byte
+}
+
+// archive is set when templates go to -out-tar rather than loose files.
+var archive *tarArchive
+
+func createTarArchive(p string) (*tarArchive, error) {
+	f, err := createAtomic(p)
+	if err != nil {
+		return nil, err
+	}
+	return &tarArchive{f: f, entries: map[string][]byte{}}, nil
+}
+
+func (a *tarArchive) add(name string, body []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[name] = append([]byte{}, body...)
+	return nil
+}
+
+// write writes every entry in path order.
+func (a *tarArchive) write(w io.Writer) error {
+	names := make([]string, 0, len(a.entries))
+	for name := range a.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		body := a.entries[name]
+		// A fixed modification time keeps archives of identical
+		// templates byte for byte identical.
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(body)),
+			ModTime: time.Unix(0, 0),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(body); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Close finishes the archive and moves it into place.
+func (a *tarArchive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.write(a.f); err != nil {
+		a.f.Abort()
+		return err
+	}
+	return a.f.Close()
+}
+
+// tarEntry buffers one template and only adds it to the archive on Close, so
+// entries from different workers never interleave.
+type tarEntry struct {
+	bytes.Buffer
+	name string
+}
+
+func (e *tarEntry) Close() error {
+	return archive.add(e.name, e.Bytes())
+}
+
+func (e *tarEntry) Abort() {
+	e.Reset()
+}
+
+func archivePath(name, filename string) string {
+	p := path.Join("srcpkgs", name, filename)
+	if multiDistro {
+		return path.Join(distro.Name, p)
+	}
+	return p
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// TestTarArchiveReproducible renders the same repositories into two archives,
+// finishing them in opposite orders as concurrent workers might, and checks
+// that the archives are byte for byte identical.
+func TestTarArchiveReproducible(t *testing.T) {
+	old := archive
+	defer func() { archive = old }()
+	tmpl, err := parseGoTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := sampleRepoData()
+	core.Name = "sample_core"
+	core.SubPackages = core.SubPackages[:1]
+	repos := map[string]*RepoData{"sample_repo": sampleRepoData(), "sample_core": core}
+
+	dir := t.TempDir()
+	var archives [][]byte
+	for i, order := range [][]string{{"sample_repo", "sample_core"}, {"sample_core", "sample_repo"}} {
+		p := path.Join(dir, string(rune('a'+i))+".tar.gz")
+		if archive, err = createTarArchive(p); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range order {
+			if err := writeTemplate(name, repos[name], tmpl, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, body)
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Error("archives differ with the order templates were written in")
+	}
+}
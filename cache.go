@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	cacheDir = flag.String("cache-dir", ".cache", "directory caching distribution.yaml and package.xml responses and tarball checksums between runs; empty disables caching")
+	noCache  = flag.Bool("no-cache", false, "ignore what -cache-dir holds and fetch everything again, still storing the results")
+	cacheTTL = flag.Duration("cache-ttl", time.Hour, "use -cache-dir responses younger than this without revalidating them; 0 always revalidates")
+)
+
+// cacheEntry holds the validators needed to revalidate a cached body with a
+// conditional request.
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// cachePath locates the cache entry for url. Entries are kept per distro so
+// that the same URL fetched for different distros never shares a body.
+func cachePath(url string) string {
+	return path.Join(*cacheDir, distro.Name, fmt.Sprintf("%x", sha256.Sum256([]byte(url))))
+}
+
+func readCacheEntry(p string) ([]byte, cacheEntry, error) {
+	var entry cacheEntry
+
+	body, err := ioutil.ReadFile(p + ".body")
+	if err != nil {
+		return nil, entry, err
+	}
+	meta, err := ioutil.ReadFile(p + ".json")
+	if err != nil {
+		return nil, entry, err
+	}
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return nil, entry, err
+	}
+	return body, entry, nil
+}
+
+func writeCacheEntry(p string, body []byte, entry cacheEntry) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p+".body", body); err != nil {
+		return err
+	}
+	return writeFileAtomic(p+".json", meta)
+}
+
+// getCachedHTTPResponseBody behaves like getHTTPResponseBody, but when
+// -cache-dir is set it revalidates a previously stored copy with
+// If-None-Match/If-Modified-Since and reuses it on 304 Not Modified.
+func getCachedHTTPResponseBody(url string) ([]byte, error) {
+	return getValidatedHTTPResponseBody(url, nil)
+}
+
+// getValidatedHTTPResponseBody is getCachedHTTPResponseBody for bodies that
+// validate can check. Only a successful, non-empty body that passes validate
+// is cached, so a failed fetch never poisons later runs.
+func getValidatedHTTPResponseBody(url string, validate func([]byte) error) ([]byte, error) {
+	p := cachePath(url)
+	header := http.Header{}
+	var cached []byte
+	if len(*cacheDir) > 0 && !*noCache {
+		var entry cacheEntry
+		var err error
+		cached, entry, err = readCacheEntry(p)
+		if err == nil && *cacheTTL > 0 {
+			if info, err := os.Stat(p + ".json"); err == nil && time.Since(info.ModTime()) < *cacheTTL {
+				return cached, nil
+			}
+		}
+		if err == nil {
+			if len(entry.ETag) > 0 {
+				header.Set("If-None-Match", entry.ETag)
+			}
+			if len(entry.LastModified) > 0 {
+				header.Set("If-Modified-Since", entry.LastModified)
+			}
+		}
+	}
+
+	resp, body, err := doHTTPRequest(url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		atomic.AddInt64(&cacheStats.revalidated, 1)
+		// Revalidating restarts the -cache-ttl of the entry.
+		now := time.Now()
+		os.Chtimes(p+".json", now, now)
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("%s: empty response", url)
+	}
+	if validate != nil {
+		if err := validate(body); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(*cacheDir) > 0 {
+		entry := cacheEntry{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		}
+		if err := writeCacheEntry(p, body, entry); err != nil {
+			warnf("caching %s: %v", url, err)
+		} else {
+			atomic.AddInt64(&cacheStats.stored, 1)
+		}
+	}
+	return body, nil
+}
+
+// cachedChecksum returns the checksum of the tarball at url stored by an
+// earlier run. Release tarballs never change, so it is never revalidated.
+func cachedChecksum(url string) (string, bool) {
+	if len(*cacheDir) == 0 || *noCache {
+		return "", false
+	}
+	body, err := ioutil.ReadFile(cachePath(url) + ".sha256")
+	if err != nil {
+		return "", false
+	}
+	return string(body), true
+}
+
+func storeChecksum(url, checksum string) {
+	if len(*cacheDir) == 0 {
+		return
+	}
+	p := cachePath(url) + ".sha256"
+	if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+		warnf("caching checksum of %s: %v", url, err)
+		return
+	}
+	if err := writeFileAtomic(p, []byte(checksum)); err != nil {
+		warnf("caching checksum of %s: %v", url, err)
+	}
+}
+
+var (
+	templateDistfiles = regexp.MustCompile(`(?m)^distfiles="([^"]*)"`)
+	templateChecksum  = regexp.MustCompile(`(?m)^checksum="?([0-9a-f]{64})"?`)
+)
+
+// existingChecksum reads the checksum out of the template name already in
+// the output directory when it fetches exactly tarball, so an unchanged
+// release isn't downloaded again.
+func existingChecksum(name, version, tarball string) (string, bool) {
+	body, err := ioutil.ReadFile(path.Join(outputDir(), name, templateFileName(name, version)))
+	if err != nil {
+		return "", false
+	}
+	d := templateDistfiles.FindSubmatch(body)
+	c := templateChecksum.FindSubmatch(body)
+	if d == nil || c == nil || string(d[1]) != escapeQuoted(tarball) {
+		return "", false
+	}
+	// -metadata-only templates only hold a placeholder.
+	if strings.Trim(string(c[1]), "0") == "" {
+		return "", false
+	}
+	return string(c[1]), true
+}
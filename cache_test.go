@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain disables the default -cache-dir so tests never share responses
+// through, or write, a .cache directory in the source tree. Tests exercising
+// the cache turn it on with useCache.
+func TestMain(m *testing.M) {
+	*cacheDir = ""
+	os.Exit(m.Run())
+}
+
+// useCache points -cache-dir at a fresh directory, with responses reused for
+// ttl without revalidation.
+func useCache(t *testing.T, ttl time.Duration) {
+	oldDir, oldTTL, oldNoCache := *cacheDir, *cacheTTL, *noCache
+	t.Cleanup(func() { *cacheDir, *cacheTTL, *noCache = oldDir, oldTTL, oldNoCache })
+	*cacheDir, *cacheTTL, *noCache = t.TempDir(), ttl, false
+}
+
+// TestCachePerDistro checks that two distros sharing a -cache-dir never see
+// each other's copy of the same URL.
+func TestCachePerDistro(t *testing.T) {
+	const url = "https://raw.githubusercontent.com/ros/rosdistro/master/index-v4.yaml"
+	useCache(t, time.Hour)
+	old := distro
+	defer func() { distro = old }()
+
+	for _, name := range []string{"melodic", "noetic"} {
+		distro = newDistro(name)
+		useFetcher(t, stubFetcher{url: name + " body"})
+		body, err := getCachedHTTPResponseBody(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != name+" body" {
+			t.Errorf("%s: got %q, want its own body", name, body)
+		}
+	}
+
+	// With the network gone each distro still reads back its own copy.
+	useFetcher(t, stubFetcher{})
+	for _, name := range []string{"melodic", "noetic"} {
+		distro = newDistro(name)
+		body, err := getCachedHTTPResponseBody(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != name+" body" {
+			t.Errorf("%s: cached %q, want its own body", name, body)
+		}
+	}
+}
+
+// TestCacheSkipsFailures checks that a 404 is never cached, so a retry that
+// succeeds stores the good body for later runs.
+func TestCacheSkipsFailures(t *testing.T) {
+	const url = "https://raw.githubusercontent.com/ros/sample_repo/melodic-devel/sample_core/package.xml"
+	useCache(t, time.Hour)
+
+	useFetcher(t, stubFetcher{})
+	if _, err := getCachedHTTPResponseBody(url); err == nil {
+		t.Fatal("404 returned without an error")
+	}
+	if _, err := os.Stat(cachePath(url) + ".body"); !os.IsNotExist(err) {
+		t.Fatalf("404 was cached: %v", err)
+	}
+
+	useFetcher(t, stubFetcher{url: "<package/>"})
+	if body, err := getCachedHTTPResponseBody(url); err != nil || string(body) != "<package/>" {
+		t.Fatalf("retry = %q, %v", body, err)
+	}
+
+	useFetcher(t, stubFetcher{})
+	if body, err := getCachedHTTPResponseBody(url); err != nil || string(body) != "<package/>" {
+		t.Errorf("cached body = %q, %v, want the successful retry", body, err)
+	}
+}
+
+// TestCacheDefaults checks that the cache is on by default, in .cache, and
+// that a cached distribution file is reused only for a limited time.
+func TestCacheDefaults(t *testing.T) {
+	if f := flag.Lookup("cache-dir"); f.DefValue != ".cache" {
+		t.Errorf("-cache-dir defaults to %q, want .cache", f.DefValue)
+	}
+	if f := flag.Lookup("cache-ttl"); f.DefValue == "0s" {
+		t.Error("-cache-ttl defaults to 0, revalidating every response")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var changedRosdep = flag.String("changed-rosdep", "", "comma-separated rosdep keys whose mapping changed; only the repositories depending on one of them are generated")
+
+// repositoriesUsing reads the package.xml files of every released repository
+// and returns the repositories depending on any of keys, matched against the
+// rosdep key each dependency resolves through. -cache-dir keeps the second
+// read, when the templates are generated, cheap.
+func repositoriesUsing(d DistroData, keys []string) []string {
+	wanted := map[string]bool{}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); len(key) > 0 {
+			wanted[key] = true
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var names []string
+	sem := make(chan struct{}, *jobs)
+	for pkgname, repodata := range d.Repositories {
+		if len(repodata.Release.URL) == 0 {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(pkgname string, repodata RepoData) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			cleanReleaseVersion(pkgname, &repodata)
+			if err := prepareAdditionalPackageData(pkgname, &repodata, nil); err != nil {
+				warnf("%s: -changed-rosdep: %v", pkgname, err)
+				return
+			}
+			for _, sp := range repodata.SubPackages {
+				for _, dep := range append(sp.BuildTimeDependencies(), sp.RunDependencies...) {
+					if wanted[rosdepKey(dep)] {
+						mu.Lock()
+						names = append(names, pkgname)
+						mu.Unlock()
+						return
+					}
+				}
+			}
+		}(pkgname, repodata)
+	}
+	wg.Wait()
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestRepositoriesUsingPythonKey checks that -changed-rosdep matches the
+// python key a dependency resolves through for the distro, not the key
+// package.xml spells.
+func TestRepositoriesUsingPythonKey(t *testing.T) {
+	oldFile, oldDistro, oldRules := *distroFilePath, distro, rosdepRules
+	defer func() { *distroFilePath, distro, rosdepRules = oldFile, oldDistro, oldRules }()
+	*distroFilePath = "testdata/rosdep/distribution.yaml"
+	useFetcher(t, rosdepFixture(t))
+	distro = newDistro("noetic")
+	if err := loadRosdepRules(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := getPackageList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sample_rosdep exec_depends on python-yaml, which noetic resolves
+	// through python3-yaml.
+	if got, want := repositoriesUsing(d, []string{"python3-yaml"}), []string{"sample_rosdep"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("repositoriesUsing(python3-yaml) = %v, want %v", got, want)
+	}
+	if got := repositoriesUsing(d, []string{"python-yaml"}); len(got) > 0 {
+		t.Errorf("repositoriesUsing(python-yaml) = %v, want none", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+var checkMode = flag.Bool("check", false, "compare every selected template with the one in the output directory instead of writing it, failing when any is invalid or out of date")
+
+// checkTemplateFile reports a StaleError unless the template name already in
+// the output directory is exactly body.
+func checkTemplateFile(pkgname, name, version string, body []byte) error {
+	p := path.Join(outputDir(), name, templateFileName(name, version))
+	old, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return &StaleError{pkgname, fmt.Errorf("%s is missing", p)}
+	} else if err != nil {
+		return &StaleError{pkgname, err}
+	}
+	if !bytes.Equal(old, body) {
+		return &StaleError{pkgname, fmt.Errorf("%s is out of date", p)}
+	}
+	return nil
+}
+
+// checkModeConflicts names the flags writing files that -check and -dry-run
+// can't be combined with.
+func checkModeConflicts() []string {
+	var set []string
+	for _, f := range []string{"state", "lockfile", "checksums-out", "summary-json", "changelog", "metapackage", "out-tar", "prune", "fetch-only", "manifest"} {
+		if v := flag.Lookup(f).Value.String(); len(v) > 0 && v != "false" {
+			set = append(set, "-"+f)
+		}
+	}
+	return set
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Diagnostic categories.
+const (
+	diagDuplicatePackage = "duplicate-package"
+	diagSkippedPackage   = "skipped-subpackage"
+	diagVersion          = "version"
+	diagUnresolved       = "unresolved-dependency"
+	diagShortDescription = "short-description"
+	diagPkgname          = "pkgname-mismatch"
+	diagUninstallable    = "uninstallable-dependency"
+	diagFormat           = "package-format"
+	diagDiverged         = "diverged-repositories"
+)
+
+// Diagnostic is a problem noticed while generating a package that didn't
+// stop it from being written.
+type Diagnostic struct {
+	Package  string `json:"package"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// Diagnostics collects diagnostics from concurrent workers for the end of run
+// summary. The zero value is ready to use.
+type Diagnostics struct {
+	mu      sync.Mutex
+	entries []Diagnostic
+}
+
+// Add logs a diagnostic about pkgname at info level and records it. A nil
+// collector only logs.
+func (d *Diagnostics) Add(pkgname, category, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	infof("%s: %s", pkgname, msg)
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.entries = append(d.entries, Diagnostic{pkgname, category, msg})
+	d.mu.Unlock()
+}
+
+// Entries returns every recorded diagnostic ordered by category and package.
+func (d *Diagnostics) Entries() []Diagnostic {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries := append([]Diagnostic{}, d.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Category != entries[j].Category {
+			return entries[i].Category < entries[j].Category
+		}
+		return entries[i].Package < entries[j].Package
+	})
+	return entries
+}
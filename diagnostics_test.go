@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestDiagnosticsConcurrentAdd is meant for go test -race.
+func TestDiagnosticsConcurrentAdd(t *testing.T) {
+	var d Diagnostics
+	var wg sync.WaitGroup
+	const workers, each = 8, 50
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				d.Add(fmt.Sprintf("pkg%d", w), diagUnresolved, "dependency %d", i)
+				d.Entries()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	entries := d.Entries()
+	if len(entries) != workers*each {
+		t.Fatalf("got %d entries, want %d", len(entries), workers*each)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Package > entries[i].Package {
+			t.Fatalf("entries not ordered by package: %s before %s", entries[i-1].Package, entries[i].Package)
+		}
+	}
+}
+
+func TestDiagnosticsEntriesOrder(t *testing.T) {
+	var d Diagnostics
+	d.Add("b", diagVersion, "x")
+	d.Add("a", diagVersion, "y")
+	d.Add("c", diagDuplicatePackage, "z")
+	tests := []Diagnostic{
+		{"c", diagDuplicatePackage, "z"},
+		{"a", diagVersion, "y"},
+		{"b", diagVersion, "x"},
+	}
+	entries := d.Entries()
+	for i, want := range tests {
+		if entries[i] != want {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want)
+		}
+	}
+
+	// A nil collector must only log.
+	var none *Diagnostics
+	none.Add("a", diagVersion, "ignored")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	discoverPaths = flag.Bool("discover-paths", false, "locate package.xml files through the GitHub trees API instead of guessing their paths")
+	pathsFile     = flag.String("paths", "", "YAML file mapping package names to the path of their package.xml, used before guessing or discovery")
+)
+
+// packagePathOverrides holds the -paths mapping.
+var packagePathOverrides map[string]string
+
+func loadPackagePathOverrides(p string) error {
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(body, &packagePathOverrides); err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	return nil
+}
+
+type githubTree struct {
+	Tree []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+	} `json:"tree"`
+	Truncated bool `json:"truncated"`
+}
+
+// packagePaths caches discovered layouts per "owner/repo@ref".
+var packagePaths sync.Map
+
+type pathsResult struct {
+	paths map[string]string
+	err   error
+}
+
+// discoverPackagePaths lists the tree of repo at ref and maps the <name> of
+// every package.xml to its path. A package.xml that can't be read is stored
+// under its directory name instead, or the empty name at the repository root.
+func discoverPackagePaths(repo, ref string) (map[string]string, error) {
+	key := repo + "@" + ref
+	if v, ok := packagePaths.Load(key); ok {
+		r := v.(pathsResult)
+		return r.paths, r.err
+	}
+
+	paths, err := fetchPackagePaths(repo, ref)
+	packagePaths.Store(key, pathsResult{paths, err})
+	return paths, err
+}
+
+func fetchPackagePaths(repo, ref string) (map[string]string, error) {
+	url := fmt.Sprintf("%s/repos/%s/git/trees/%s?recursive=1", githubAPIURL, repo, ref)
+	resp, body, err := doHTTPRequest(url, githubAPIHeader())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	var tree githubTree
+	if err := json.Unmarshal(body, &tree); err != nil {
+		return nil, err
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("%s: tree listing truncated", url)
+	}
+
+	paths := map[string]string{}
+	for _, entry := range tree.Tree {
+		if entry.Type != "blob" || path.Base(entry.Path) != "package.xml" {
+			continue
+		}
+		if name, err := packageXMLName(repo, ref, entry.Path); err == nil {
+			paths[name] = entry.Path
+			continue
+		}
+		dir := path.Dir(entry.Path)
+		if dir == "." {
+			paths[""] = entry.Path
+		} else {
+			paths[path.Base(dir)] = entry.Path
+		}
+	}
+	return paths, nil
+}
+
+// packageXMLName reads the <name> of the package.xml at p, which needn't
+// match the directory it is in.
+func packageXMLName(repo, ref, p string) (string, error) {
+	u, err := forgeRepo{"github.com", repo}.rawURL(ref, p)
+	if err != nil {
+		return "", err
+	}
+	body, err := getCachedHTTPResponseBody(u)
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		Name string `xml:"name"`
+	}
+	if err := xml.Unmarshal(body, &pkg); err != nil {
+		return "", err
+	}
+	if name := strings.TrimSpace(pkg.Name); len(name) > 0 {
+		return name, nil
+	}
+	return "", fmt.Errorf("%s: no <name>", u)
+}
+
+// discoveredPackageXMLPath returns the discovered path of name's package.xml,
+// falling back to a root package.xml for single-package repositories.
+func discoveredPackageXMLPath(repo, ref, name string) (string, bool) {
+	paths, err := discoverPackagePaths(repo, ref)
+	if err != nil {
+		return "", false
+	}
+	if p, ok := paths[name]; ok {
+		return p, true
+	}
+	if p, ok := paths[""]; ok && len(paths) == 1 {
+		return p, true
+	}
+	return "", false
+}
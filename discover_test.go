@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestDiscoverPackagePathsByName(t *testing.T) {
+	useFetcher(t, stubFetcher{
+		githubAPIURL + "/repos/ros/geometry/git/trees/1.0.0?recursive=1": `{"tree": [
+			{"path": "tf_pkg/package.xml", "type": "blob"},
+			{"path": "eigen_conversions/package.xml", "type": "blob"},
+			{"path": "broken/package.xml", "type": "blob"},
+			{"path": "README.md", "type": "blob"}
+		]}`,
+		githubRawURL + "/ros/geometry/1.0.0/tf_pkg/package.xml":            `<package><name>tf</name></package>`,
+		githubRawURL + "/ros/geometry/1.0.0/eigen_conversions/package.xml": `<package><name>eigen_conversions</name></package>`,
+	})
+
+	tests := []struct {
+		name, want string
+		ok         bool
+	}{
+		{"tf", "tf_pkg/package.xml", true},
+		{"eigen_conversions", "eigen_conversions/package.xml", true},
+		{"broken", "broken/package.xml", true},
+		{"tf_pkg", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := discoveredPackageXMLPath("ros/geometry", "1.0.0", tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("discoveredPackageXMLPath(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestPathOverridesBeforeDiscovery checks that a -paths entry is used as is,
+// without asking the GitHub API where package.xml is.
+func TestPathOverridesBeforeDiscovery(t *testing.T) {
+	oldDiscover, oldOverrides := *discoverPaths, packagePathOverrides
+	defer func() { *discoverPaths, packagePathOverrides = oldDiscover, oldOverrides }()
+	*discoverPaths = true
+	if err := loadPackagePathOverrides("testdata/paths/paths.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	f := &countingFetcher{stubFetcher: stubFetcher{
+		githubAPIURL + "/repos/ros/geometry/git/trees/1.0.0?recursive=1": `{"tree": [{"path": "tf/package.xml", "type": "blob"}]}`,
+	}}
+	useFetcher(t, f)
+
+	got, err := packageXMLURL("tf", "1.0.0", "https://github.com/ros/geometry.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := githubRawURL + "/ros/geometry/1.0.0/geometry/tf_pkg/package.xml"; got != want {
+		t.Errorf("packageXMLURL = %q, want the -paths entry %q", got, want)
+	}
+	if len(f.count) != 0 {
+		t.Errorf("discovery ran despite the override: %v", f.count)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Distro describes the ROS distribution templates are being generated for.
+type Distro struct {
+	Name string
+
+	// PythonVersion and PythonABIFlags locate the python the distro is built
+	// against, e.g. "3.6" and "m" for /usr/include/python3.6m.
+	PythonVersion  string
+	PythonABIFlags string
+}
+
+var knownDistros = map[string]Distro{
+	"melodic": {PythonVersion: "3.6", PythonABIFlags: "m"},
+	"noetic":  {PythonVersion: "3.8"},
+}
+
+// distro is the distribution currently being generated. Runs over several
+// distros switch it between them, never while workers are running.
+var distro = newDistro("melodic")
+
+// multiDistro is set when one run covers several distros, which scopes every
+// output path by distro name.
+var multiDistro bool
+
+func newDistro(name string) *Distro {
+	d, ok := knownDistros[name]
+	if !ok {
+		warnf("unknown distro %s, assuming the noetic python setup", name)
+		d = knownDistros["noetic"]
+	}
+	d.Name = name
+	return &d
+}
+
+const rosdistroIndexURL = "https://raw.githubusercontent.com/ros/rosdistro/master/index-v4.yaml"
+
+// rosdistroIndex is the part of rosdistro's index-v4.yaml naming the
+// distribution files of every distro, relative to the index itself.
+type rosdistroIndex struct {
+	Distributions map[string]struct {
+		Distribution []string
+	}
+}
+
+func (d *Distro) ListURL() string {
+	return fmt.Sprintf("https://raw.githubusercontent.com/ros/rosdistro/master/%s/distribution.yaml", d.Name)
+}
+
+// DistributionURLs returns the distribution files listed for d in the
+// rosdistro index, falling back to ListURL when the index can't be used.
+func (d *Distro) DistributionURLs() []string {
+	urls, err := d.indexDistributionURLs()
+	if err != nil {
+		warnf("rosdistro index: %v, using %s", err, d.ListURL())
+		return []string{d.ListURL()}
+	}
+	return urls
+}
+
+func (d *Distro) indexDistributionURLs() ([]string, error) {
+	body, err := getCachedHTTPResponseBody(rosdistroIndexURL)
+	if err != nil {
+		return nil, err
+	}
+	var index rosdistroIndex
+	if err := yaml.Unmarshal(body, &index); err != nil {
+		return nil, err
+	}
+	files := index.Distributions[d.Name].Distribution
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no distribution files for %s", d.Name)
+	}
+
+	base, err := url.Parse(rosdistroIndexURL)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(files))
+	for _, f := range files {
+		ref, err := url.Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, base.ResolveReference(ref).String())
+	}
+	return urls, nil
+}
+
+// Prefix is prepended to every generated package name, "ros-<distro>-"
+// unless overridden with -prefix.
+func (d *Distro) Prefix() string {
+	if len(*packagePrefix) > 0 {
+		return *packagePrefix
+	}
+	return "ros-" + d.Name + "-"
+}
+
+// PythonTag is the version used in extension suffixes, e.g. "36m".
+func (d *Distro) PythonTag() string {
+	return strings.ReplaceAll(d.PythonVersion, ".", "") + d.PythonABIFlags
+}
+
+func outputDir() string {
+	if multiDistro {
+		return path.Join(outputPath, distro.Name)
+	}
+	return outputPath
+}
+
+// distroFile scopes a per-run file such as -state to the current distro when
+// several distros are generated at once, turning "state.json" into
+// "state.noetic.json".
+func distroFile(p string) string {
+	if !multiDistro || len(p) == 0 {
+		return p
+	}
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + distro.Name + ext
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"sync"
+)
+
+var dryRun = flag.Bool("dry-run", false, "print every template to stdout, each line prefixed with its package name, instead of writing the output directory")
+
+// stdoutMu keeps the templates of concurrent workers from interleaving.
+var stdoutMu sync.Mutex
+
+// stdoutEntry buffers one template and prints it on Close for -dry-run.
+type stdoutEntry struct {
+	bytes.Buffer
+	name string
+}
+
+func (e *stdoutEntry) Close() error {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+
+	s := bufio.NewScanner(&e.Buffer)
+	for s.Scan() {
+		fmt.Printf("%s: %s\n", e.name, s.Text())
+	}
+	return s.Err()
+}
+
+func (e *stdoutEntry) Abort() {
+	e.Reset()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/template"
+)
+
+var (
+	dumpData = flag.String("dump-data", "", "print the fully populated template data of this package as JSON instead of writing its template")
+	dataIn   = flag.String("data-in", "", "render the template from a -dump-data JSON file to stdout without fetching anything")
+)
+
+func dumpRepoData(d DistroData, name string) (bool, error) {
+	repodata, ok := findPackage(d, name)
+	if !ok {
+		return false, fmt.Errorf("unknown package %s", name)
+	}
+	// A skipped repository is still dumped, as far as it was prepared.
+	var skip *SkipError
+	if err := prepareRepoData(repodata.Name, repodata, nil); err != nil && !errors.As(err, &skip) {
+		return false, err
+	}
+	body, err := json.MarshalIndent(repodata, "", "\t")
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("%s\n", body)
+	return true, nil
+}
+
+// renderDataFile renders tmpl from template data dumped with -dump-data.
+func renderDataFile(p string, tmpl *template.Template) error {
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	var repodata RepoData
+	if err := json.Unmarshal(body, &repodata); err != nil {
+		return fmt.Errorf("%s: %v", p, err)
+	}
+	if repodata.Distro != nil {
+		// Template functions such as prefix read the global distro.
+		distro = repodata.Distro
+	}
+	return tmpl.Execute(os.Stdout, &repodata)
+}
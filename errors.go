@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// FetchError is returned when a file needed for a package couldn't be
+// downloaded.
+type FetchError struct {
+	Package string
+	Err     error
+}
+
+func (e *FetchError) Error() string { return fmt.Sprintf("%s: fetch: %v", e.Package, e.Err) }
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// ParseError is returned when a downloaded package.xml can't be understood.
+type ParseError struct {
+	Package string
+	Err     error
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("%s: parse: %v", e.Package, e.Err) }
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ChecksumError is returned when a package's tarball couldn't be checksummed.
+type ChecksumError struct {
+	Package string
+	Err     error
+}
+
+func (e *ChecksumError) Error() string { return fmt.Sprintf("%s: checksum: %v", e.Package, e.Err) }
+func (e *ChecksumError) Unwrap() error { return e.Err }
+
+// WriteError is returned when a template couldn't be rendered or written.
+type WriteError struct {
+	Package string
+	Err     error
+}
+
+func (e *WriteError) Error() string { return fmt.Sprintf("%s: write: %v", e.Package, e.Err) }
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// StaleError is returned by -check when a template on disk doesn't match the
+// one that would be generated.
+type StaleError struct {
+	Package string
+	Err     error
+}
+
+func (e *StaleError) Error() string { return fmt.Sprintf("%s: stale: %v", e.Package, e.Err) }
+func (e *StaleError) Unwrap() error { return e.Err }
+
+// Skip reasons.
+const (
+	skipUpToDate    = "up to date"
+	skipBlacklisted = "blacklisted"
+	skipNoRelease   = "no release"
+	skipNoVersion   = "no release or source version"
+	skipBranch      = "no release version and the source version is a branch"
+)
+
+// SkipError is returned when a package is deliberately not generated.
+type SkipError struct {
+	Package string
+	Reason  string
+}
+
+func (e *SkipError) Error() string { return fmt.Sprintf("%s: skipped: %s", e.Package, e.Reason) }
+
+// RenderError is returned when the template fails to execute for a package.
+// Field is the template expression that failed, when the error names one.
+type RenderError struct {
+	Package string
+	Field   string
+	Err     error
+}
+
+func (e *RenderError) Error() string {
+	if len(e.Field) > 0 {
+		return fmt.Sprintf("%s: render %s: %v", e.Package, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: render: %v", e.Package, e.Err)
+}
+func (e *RenderError) Unwrap() error { return e.Err }
+
+// templateField matches the expression text/template reports an execution
+// error at, e.g. `at <.Foo>`.
+var templateField = regexp.MustCompile(`at <([^>]*)>`)
+
+func newRenderError(pkgname string, err error) *RenderError {
+	e := &RenderError{Package: pkgname, Err: err}
+	if m := templateField.FindStringSubmatch(err.Error()); m != nil {
+		e.Field = m[1]
+	}
+	return e
+}
+
+// errorCategory names the kind of failure err is, for grouping in summaries.
+func errorCategory(err error) string {
+	var fetchErr *FetchError
+	var parseErr *ParseError
+	var checksumErr *ChecksumError
+	var writeErr *WriteError
+	var renderErr *RenderError
+	var staleErr *StaleError
+
+	switch {
+	case errors.As(err, &staleErr):
+		return "stale"
+	case errors.As(err, &fetchErr):
+		return "fetch"
+	case errors.As(err, &parseErr):
+		return "parse"
+	case errors.As(err, &checksumErr):
+		return "checksum"
+	case errors.As(err, &writeErr):
+		return "write"
+	case errors.As(err, &renderErr):
+		return "render"
+	}
+	return "other"
+}
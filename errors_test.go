@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// TestRenderErrorNamesPackage checks that a template referencing a field
+// RepoData doesn't have fails with a RenderError naming the package and the
+// field.
+func TestRenderErrorNamesPackage(t *testing.T) {
+	old := outputPath
+	defer func() { outputPath = old }()
+	outputPath = t.TempDir()
+	tmpl := template.Must(template.New("broken").Funcs(templateFuncs).Parse("pkgname={{.NoSuchField}}\n"))
+
+	err := writeTemplate("sample_repo", sampleRepoData(), tmpl, nil)
+	var renderErr *RenderError
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("writeTemplate = %v, want a RenderError", err)
+	}
+	if renderErr.Package != "sample_repo" || renderErr.Field != ".NoSuchField" {
+		t.Errorf("RenderError for %q at %q, want sample_repo at .NoSuchField", renderErr.Package, renderErr.Field)
+	}
+	if !strings.HasPrefix(err.Error(), "sample_repo: render .NoSuchField: ") {
+		t.Errorf("error = %q", err)
+	}
+	if got := errorCategory(err); got != "render" {
+		t.Errorf("errorCategory = %q, want render", got)
+	}
+}
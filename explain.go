@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+var explainPackage = flag.String("explain", "", "trace every decision made while generating this package and print the rendered template instead of writing it")
+
+// explainDependency describes what becomes of a package.xml dependency.
+func explainDependency(d DistroData, dep string) string {
+	switch {
+	case isHostTool(dep):
+		return "host tool, kept as " + dep
+	case ignoreList[dep]:
+		return "ignored"
+	}
+	if pkgs, ok := rosdepPackages(dep); ok {
+		return "rosdep key, mapped to " + strings.Join(pkgs, " ")
+	}
+	name, _ := voidDependencyName(dep)
+	if _, ok := findPackage(d, dep); ok {
+		return "ROS package " + name
+	}
+	return fmt.Sprintf("unresolved, %s is not released for %s", name, distro.Name)
+}
+
+func explainDependencies(d DistroData, kind string, deps []string) {
+	fmt.Printf("%s:\n", kind)
+	for _, dep := range deps {
+		fmt.Printf("\t%s: %s\n", dep, explainDependency(d, dep))
+	}
+}
+
+func explain(d DistroData, name string, tmpl *template.Template) bool {
+	repodata, ok := findPackage(d, name)
+	if !ok {
+		fmt.Printf("%s is not in the %s distribution\n", name, distro.Name)
+		return false
+	}
+	reponame := repodata.Name
+	fmt.Printf("%s is released by repository %s\n", name, reponame)
+	fmt.Printf("release: %s at %s\n", repodata.Release.URL, repodata.Release.Version)
+	fmt.Printf("source: %s at %s\n", repodata.Source.URL, repodata.Source.Version)
+
+	// Work on a copy so prepareRepoData below starts from the distribution
+	// data as it was read.
+	explained := *repodata
+	cleanReleaseVersion(reponame, &explained)
+
+	locate := func(pkg string) (string, error) {
+		return packageXMLURL(pkg, explained.Source.Version, explained.Source.URL)
+	}
+	if repositoriesDiverge(&explained) {
+		fmt.Println("source and release repositories diverge, reading package.xml from the release")
+		locate = func(pkg string) (string, error) {
+			return releasePackageXMLURL(pkg, explained.Release.Version, explained.Release.URL)
+		}
+	}
+
+	names := uniquePackageNames(reponame, explained.Release.Packages, nil)
+	if len(names) == 0 {
+		names = []string{reponame}
+	}
+	for _, pkg := range names {
+		if rawurl, err := locate(pkg); err != nil {
+			fmt.Printf("%s: no package.xml URL: %v\n", pkg, err)
+		} else {
+			fmt.Printf("%s: package.xml at %s\n", pkg, rawurl)
+		}
+	}
+
+	// prepareRepoData fetches every package.xml and the checksum exactly as
+	// a normal run would; the trace below reports what it found.
+	var diag Diagnostics
+	var skip *SkipError
+	err := prepareRepoData(reponame, repodata, &diag)
+	for _, e := range diag.Entries() {
+		fmt.Printf("%s: %s\n", e.Package, e.Message)
+	}
+	if errors.As(err, &skip) {
+		fmt.Printf("skipped: %s, nothing is generated\n", skip.Reason)
+		return true
+	} else if err != nil {
+		fmt.Printf("failed: %v\n", err)
+		return false
+	}
+
+	for _, sp := range repodata.SubPackages {
+		format := sp.Format
+		if len(format) == 0 {
+			format = "1 (no format attribute)"
+		}
+		fmt.Printf("\n%s: ok\n", sp.Name)
+		fmt.Printf("format: %s\n", format)
+		fmt.Printf("buildtool_depend: %s\n", strings.Join(sp.BuildDependencies, " "))
+		fmt.Printf("buildtool_export_depend: %s\n", strings.Join(sp.BuildToolExportDependencies, " "))
+		fmt.Printf("build_depend: %s\n", strings.Join(sp.BuildDepends, " "))
+		fmt.Printf("run dependencies: %s\n", strings.Join(sp.RunDependencies, " "))
+		explainDependencies(d, "hostmakedepends", withHostBaseline(sp.Name, sp.HostMakeDependencies()))
+		explainDependencies(d, "makedepends", sp.MakeDependencies())
+		explainDependencies(d, "depends", sp.RuntimeDependencies())
+	}
+
+	if len(repodata.Release.URL) == 0 {
+		fmt.Println("\nno release, -allow-source builds the source archive")
+	}
+	fmt.Printf("\ntarball: %s\n", repodata.TarballURL)
+	fmt.Printf("checksum: %s, from %s\n", repodata.CheckSum, repodata.checksumSource)
+
+	fmt.Printf("\nrendered template for %s%s:\n", currentPrefix(), formatPackageName(reponame))
+	if err := tmpl.Execute(os.Stdout, repodata); err != nil {
+		fmt.Printf("failed: %v\n", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync/atomic"
+)
+
+var fetchOnly = flag.Bool("fetch-only", false, "fetch package.xml files, checksums and rosdep rules into the caches without rendering or writing templates")
+
+// cacheStats counts how -cache-dir entries were used, reported after a
+// -fetch-only run.
+var cacheStats struct {
+	revalidated int64
+	stored      int64
+}
+
+func printCacheStats() {
+	fmt.Printf("cache: %d revalidated, %d stored\n", atomic.LoadInt64(&cacheStats.revalidated), atomic.LoadInt64(&cacheStats.stored))
+}
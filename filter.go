@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+)
+
+var filterPattern = flag.String("filter", "", "generate only the repositories whose name matches this regular expression")
+
+// packageFilter is the compiled -filter, nil when every repository is
+// generated.
+var packageFilter *regexp.Regexp
+
+// filteredRepositories returns the repositories of d matching -filter.
+func filteredRepositories(d DistroData) map[string]RepoData {
+	if packageFilter == nil {
+		return d.Repositories
+	}
+	repos := map[string]RepoData{}
+	for name, repodata := range d.Repositories {
+		if packageFilter.MatchString(name) {
+			repos[name] = repodata
+		}
+	}
+	return repos
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// forgeRepo is a repository URL split into the forge hosting it and the
+// project path on that forge, e.g. "ros/ros_comm" or a GitLab
+// "group/subgroup/project".
+type forgeRepo struct {
+	Host    string
+	Project string
+}
+
+func parseForgeRepo(rawurl string) (forgeRepo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return forgeRepo{}, err
+	}
+	project := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if len(u.Host) == 0 || !strings.Contains(project, "/") {
+		return forgeRepo{}, fmt.Errorf("%s: not a repository URL", rawurl)
+	}
+	return forgeRepo{strings.ToLower(u.Host), project}, nil
+}
+
+func (r forgeRepo) isGitHub() bool {
+	return r.Host == "github.com"
+}
+
+func (r forgeRepo) isGitLab() bool {
+	return r.Host == "gitlab.com" || strings.HasPrefix(r.Host, "gitlab.")
+}
+
+// rawURL returns where file p of the repository can be downloaded at ref.
+// Hosts whose raw URLs aren't known get an error naming -allow-git-clone.
+func (r forgeRepo) rawURL(ref, p string) (string, error) {
+	p = strings.TrimPrefix(p, "/")
+	switch {
+	case r.isGitHub():
+		return fmt.Sprintf("%s/%s/%s/%s", githubRawURL, r.Project, ref, p), nil
+	case r.isGitLab():
+		return fmt.Sprintf("https://%s/%s/-/raw/%s/%s", r.Host, r.Project, ref, p), nil
+	case r.Host == "bitbucket.org":
+		return fmt.Sprintf("https://%s/%s/raw/%s/%s", r.Host, r.Project, ref, p), nil
+	}
+	return "", fmt.Errorf("%s: can't download single files from this host, -allow-git-clone reads package.xml from a clone instead", r.Host)
+}
+
+// archiveHost returns the host the forge redirects archive downloads to, when
+// it doesn't serve them itself.
+func (r forgeRepo) archiveHost() (string, bool) {
+	if r.isGitHub() {
+		return "codeload.github.com", true
+	}
+	return "", false
+}
+
+// archiveURL returns the tarball of the repository at ref.
+func (r forgeRepo) archiveURL(ref string) (string, error) {
+	switch {
+	case r.isGitHub():
+		return fmt.Sprintf("https://%s/%s/archive/%s.tar.gz", r.Host, r.Project, ref), nil
+	case r.isGitLab():
+		name := r.Project[strings.LastIndex(r.Project, "/")+1:]
+		return fmt.Sprintf("https://%s/%s/-/archive/%s/%s-%s.tar.gz", r.Host, r.Project, ref, name, strings.Replace(ref, "/", "-", -1)), nil
+	case r.Host == "bitbucket.org":
+		return fmt.Sprintf("https://%s/%s/get/%s.tar.gz", r.Host, r.Project, ref), nil
+	}
+	return "", fmt.Errorf("no archive URL for repositories on %s", r.Host)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+// TestParseForgeRepoGitMidPath checks that only a trailing .git is stripped,
+// leaving owners and groups that merely contain it alone.
+func TestParseForgeRepoGitMidPath(t *testing.T) {
+	tests := []struct {
+		url, host, project string
+	}{
+		{"https://gitlab.com/foo.github/bar.git", "gitlab.com", "foo.github/bar"},
+		{"https://gitlab.com/foo.git/bar.git", "gitlab.com", "foo.git/bar"},
+		{"https://github.com/ros/roscpp_core.git", "github.com", "ros/roscpp_core"},
+		{"https://github.com/owner/repo.github.io", "github.com", "owner/repo.github.io"},
+	}
+	for _, tt := range tests {
+		r, err := parseForgeRepo(tt.url)
+		if err != nil {
+			t.Errorf("parseForgeRepo(%q): %v", tt.url, err)
+			continue
+		}
+		if r.Host != tt.host || r.Project != tt.project {
+			t.Errorf("parseForgeRepo(%q) = %s %s, want %s %s", tt.url, r.Host, r.Project, tt.host, tt.project)
+		}
+	}
+}
+
+func TestArchiveHost(t *testing.T) {
+	tests := []struct {
+		host, want string
+	}{
+		{"github.com", "codeload.github.com"},
+		{"gitlab.com", ""},
+		{"bitbucket.org", ""},
+	}
+	for _, tt := range tests {
+		if got, _ := (forgeRepo{Host: tt.host}).archiveHost(); got != tt.want {
+			t.Errorf("%s: archiveHost = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
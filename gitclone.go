@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+var allowGitClone = flag.Bool("allow-git-clone", false, "when package.xml can't be downloaded, shallow-clone the repository and read it from the clone")
+
+// clonePackageXML shallow-clones repoURL at ref into a temporary directory and
+// reads the package.xml of name from it, either from its own directory or the
+// repository root.
+func clonePackageXML(name, repoURL, ref string) (*SubPackage, error) {
+	if err := checkSecureURL(repoURL); err != nil {
+		return nil, &FetchError{name, err}
+	}
+
+	dir, err := ioutil.TempDir("", "void-ros-clone-")
+	if err != nil {
+		return nil, &FetchError{name, err}
+	}
+	defer os.RemoveAll(dir)
+
+	// The clone is bounded like any request: by -timeout and by the
+	// interrupt cancelling requestCtx.
+	ctx, cancel := requestCtx, context.CancelFunc(func() {})
+	if *httpTimeout > 0 {
+		ctx, cancel = context.WithTimeout(requestCtx, *httpTimeout)
+	}
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "clone", "--quiet", "--depth", "1", "--branch", ref, repoURL, dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, &FetchError{name, fmt.Errorf("git clone %s %s: %v: %s", repoURL, ref, err, strings.TrimSpace(string(out)))}
+	}
+
+	for _, p := range []string{path.Join(dir, name, "package.xml"), path.Join(dir, "package.xml")} {
+		body, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, &FetchError{name, err}
+		}
+		return parsePackageXML(name, body)
+	}
+	return nil, &FetchError{name, fmt.Errorf("no package.xml in the clone of %s at %s", repoURL, ref)}
+}
+
+// withCloneFallback retries a failed download of name's package.xml from a
+// clone of repoURL at ref when -allow-git-clone is set.
+func withCloneFallback(name, repoURL, ref string, sp *SubPackage, err error) (*SubPackage, error) {
+	if _, ok := err.(*FetchError); !ok || !*allowGitClone {
+		return sp, err
+	}
+	return clonePackageXML(name, repoURL, ref)
+}
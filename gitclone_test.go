@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+// bareRepo creates a bare git repository holding the sample_core package.xml
+// of testdata/subpackages, tagged 1.2.3, and returns its file:// URL.
+func bareRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	work, bare := t.TempDir(), path.Join(t.TempDir(), "sample_repo.git")
+	body, err := ioutil.ReadFile("testdata/subpackages/sample_core/package.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(work, "sample_core"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(work, "sample_core", "package.xml"), body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-C", work, "init", "--quiet"},
+		{"-C", work, "add", "."},
+		{"-C", work, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "sample"},
+		{"-C", work, "tag", "1.2.3"},
+		{"clone", "--quiet", "--bare", work, bare},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return "file://" + bare
+}
+
+func TestClonePackageXML(t *testing.T) {
+	old := *allowInsecure
+	defer func() { *allowInsecure = old }()
+	*allowInsecure = true
+	url := bareRepo(t)
+
+	sp, err := clonePackageXML("sample_core", url, "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Name != "sample_core" || sp.Description != "Core libraries of the sample repository." {
+		t.Errorf("cloned package.xml = %+v", sp)
+	}
+	if _, err := clonePackageXML("sample_core", url, "9.9.9"); err == nil {
+		t.Error("cloned a ref that doesn't exist")
+	}
+}
+
+// TestClonePackageXMLTimeout checks that -timeout bounds the clone.
+func TestClonePackageXMLTimeout(t *testing.T) {
+	oldInsecure, oldTimeout := *allowInsecure, *httpTimeout
+	defer func() { *allowInsecure, *httpTimeout = oldInsecure, oldTimeout }()
+	*allowInsecure = true
+	url := bareRepo(t)
+
+	*httpTimeout = time.Nanosecond
+	if _, err := clonePackageXML("sample_core", url, "1.2.3"); err == nil {
+		t.Error("clone finished within a nanosecond -timeout")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var githubTokenFlag = flag.String("github-token", "", "token sent with requests to GitHub to raise its rate limit, defaulting to $GITHUB_TOKEN")
+
+// githubToken returns the -github-token or $GITHUB_TOKEN, empty for
+// unauthenticated requests.
+func githubToken() string {
+	if len(*githubTokenFlag) > 0 {
+		return *githubTokenFlag
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// isGitHubHost reports whether host is github.com or one of the GitHub hosts
+// serving raw files, archives and the API.
+func isGitHubHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "github.com" || strings.HasSuffix(host, ".github.com") || host == "raw.githubusercontent.com"
+}
+
+// authorizeGitHub adds the token to req when it goes to GitHub and doesn't
+// carry credentials already.
+func authorizeGitHub(req *http.Request) {
+	token := githubToken()
+	if len(token) == 0 || !isGitHubHost(req.URL.Hostname()) || len(req.Header.Get("Authorization")) > 0 {
+		return
+	}
+	req.Header.Set("Authorization", "token "+token)
+}
+
+// logRateLimit reports what is left of the GitHub rate limit after resp.
+func logRateLimit(resp *http.Response) {
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); len(remaining) > 0 {
+		debugf("%s: github rate limit %s of %s remaining", resp.Request.URL, remaining, resp.Header.Get("X-RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+var headCheck = flag.Bool("head-check", false, "report when the distribution files were last modified and whether they changed since the -state run, without generating")
+
+// checkDistributionFreshness prints the Last-Modified and ETag of every
+// distribution file, and compares their checksum with the one the -state
+// file recorded, reporting whether a regeneration is warranted.
+func checkDistributionFreshness() (bool, error) {
+	for _, u := range distro.DistributionURLs() {
+		if err := checkSecureURL(u); err != nil {
+			return false, err
+		}
+		release := acquireHost(u)
+		limiter.Wait()
+		req, err := http.NewRequestWithContext(requestCtx, "HEAD", u, nil)
+		if err != nil {
+			release()
+			return false, err
+		}
+		resp, err := httpClient.Do(req)
+		release()
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("%s: %s", u, resp.Status)
+		}
+		fmt.Printf("%s\n\tlast modified: %s\n\tetag: %s\n", u, resp.Header.Get("Last-Modified"), resp.Header.Get("ETag"))
+	}
+
+	if len(*stateFile) == 0 {
+		return true, nil
+	}
+	state, err := loadState(distroFile(*stateFile))
+	if err != nil {
+		return false, err
+	}
+	d, err := getPackageList()
+	if err != nil {
+		return false, err
+	}
+	switch state.Distribution {
+	case "":
+		fmt.Println("no previous run recorded in the state file")
+	case d.Checksum:
+		fmt.Println("unchanged since the last run")
+	default:
+		fmt.Println("changed since the last run")
+	}
+	return true, nil
+}
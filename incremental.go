@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+var incremental = flag.Bool("incremental", false, "skip, before fetching anything, every repository whose template in the output directory already has its version= and revision=")
+
+var (
+	templateVersion  = regexp.MustCompile(`(?m)^version=(\S+)$`)
+	templateRevision = regexp.MustCompile(`(?m)^revision=(\d+)$`)
+)
+
+// templateCurrent reports whether the template of pkgname in the output
+// directory was generated from the release version. Split sub-package
+// templates are named after their package.xml, so their repository never is
+// current and is always fetched.
+func templateCurrent(pkgname, version string) bool {
+	want, err := formatVersionString(version)
+	if err != nil {
+		return false
+	}
+	name := currentPrefix() + formatPackageName(pkgname)
+	body, err := ioutil.ReadFile(path.Join(outputDir(), name, templateFileName(name, version)))
+	if err != nil {
+		return false
+	}
+	v := templateVersion.FindSubmatch(body)
+	r := templateRevision.FindSubmatch(body)
+	return v != nil && r != nil && string(v[1]) == want && string(r[1]) == strconv.Itoa(formatRevision(version))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+// defaultLicense is used for packages that declare no license.
+const defaultLicense = "BSD-3-Clause"
+
+// spdxLicenses maps the license strings common in ROS package.xml files,
+// lowercased, to the SPDX identifiers xbps-src expects.
+var spdxLicenses = map[string]string{
+	"bsd":                         "BSD-3-Clause",
+	"bsd 3-clause":                "BSD-3-Clause",
+	"bsd-3-clause":                "BSD-3-Clause",
+	"3-clause bsd":                "BSD-3-Clause",
+	"new bsd":                     "BSD-3-Clause",
+	"bsd 2-clause":                "BSD-2-Clause",
+	"bsd-2-clause":                "BSD-2-Clause",
+	"2-clause bsd":                "BSD-2-Clause",
+	"apache 2.0":                  "Apache-2.0",
+	"apache-2.0":                  "Apache-2.0",
+	"apache license 2.0":          "Apache-2.0",
+	"apache license, version 2.0": "Apache-2.0",
+	"apache2":                     "Apache-2.0",
+	"mit":                         "MIT",
+	"zlib":                        "Zlib",
+	"boost":                       "BSL-1.0",
+	"boost software license":      "BSL-1.0",
+	"mpl-2.0":                     "MPL-2.0",
+	"mozilla public license 2.0":  "MPL-2.0",
+	"lgpl":                        "LGPL-2.1-or-later",
+	"lgplv2.1":                    "LGPL-2.1-or-later",
+	"lgpl-2.1":                    "LGPL-2.1-or-later",
+	"lgplv3":                      "LGPL-3.0-or-later",
+	"lgpl-3.0":                    "LGPL-3.0-or-later",
+	"gpl":                         "GPL-2.0-or-later",
+	"gplv2":                       "GPL-2.0-or-later",
+	"gpl-2.0":                     "GPL-2.0-or-later",
+	"gplv3":                       "GPL-3.0-or-later",
+	"gpl-3.0":                     "GPL-3.0-or-later",
+	"public domain":               "Public Domain",
+	"cc-by-4.0":                   "CC-BY-4.0",
+}
+
+// formatLicense renders the package.xml licenses as the comma separated SPDX
+// list of a license= field. Unknown licenses are kept as written and
+// repeats are dropped.
+func formatLicense(licenses []string) string {
+	seen := map[string]bool{}
+	var out []string
+	for _, l := range licenses {
+		l = strings.TrimSpace(l)
+		if len(l) == 0 {
+			continue
+		}
+		if spdx, ok := spdxLicenses[strings.ToLower(l)]; ok {
+			l = spdx
+		}
+		if !seen[l] {
+			seen[l] = true
+			out = append(out, l)
+		}
+	}
+	if len(out) == 0 {
+		return defaultLicense
+	}
+	return strings.Join(out, ", ")
+}
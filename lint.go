@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var lintTemplate = flag.Bool("lint-template", false, "render the template against sample data and report problems instead of generating")
+
+// Fields every Void template has to define with a non-empty value.
+var requiredTemplateFields = []string{
+	"pkgname", "version", "revision", "short_desc", "maintainer",
+	"license", "homepage", "distfiles", "checksum",
+}
+
+var templateAssignment = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// sampleRepoData is a representative two-package repository used to exercise
+// every branch of a template.
+func sampleRepoData() *RepoData {
+	r := &RepoData{
+		Name:       "sample_repo",
+		Distro:     distro,
+		TarballURL: "https://github.com/ros-gbp/sample_repo-release/archive/release/melodic/sample_repo/1.2.3-1.tar.gz",
+		CheckSum:   "0000000000000000000000000000000000000000000000000000000000000000",
+		Vars:       templateVars,
+		Wrksrc:     "sample_repo-release-release-melodic-sample_repo-1.2.3-1",
+	}
+	r.Release.URL = "https://github.com/ros-gbp/sample_repo-release.git"
+	r.Release.Version = "1.2.3-1"
+	r.Release.Packages = []string{"sample_core", "sample_tools"}
+	r.Source.URL = "https://github.com/ros/sample_repo.git"
+	r.Source.Version = "melodic-devel"
+	r.SubPackages = []*SubPackage{
+		{
+			Name:              "sample_core",
+			Description:       "Core libraries of the sample repository.",
+			BuildDependencies: []string{"catkin"},
+			RunDependencies:   []string{"roscpp", "std_msgs"},
+			Authors:           []Person{{Name: "Jane Doe", Email: "jane@example.com"}},
+		},
+		{
+			Name:            "sample_tools",
+			Description:     "Command line tools for the sample repository.",
+			RunDependencies: []string{"sample_core", "rospy"},
+		},
+	}
+	return r
+}
+
+// lintRenderedTemplate performs structural checks on a rendered template.
+func lintRenderedTemplate(out string) []string {
+	var problems []string
+
+	depth := 0
+	for i, line := range strings.Split(out, "\n") {
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth < 0 {
+			problems = append(problems, fmt.Sprintf("line %d: unmatched }", i+1))
+			depth = 0
+		}
+	}
+	if depth > 0 {
+		problems = append(problems, fmt.Sprintf("%d unclosed {", depth))
+	}
+
+	values := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if m := templateAssignment.FindStringSubmatch(line); m != nil {
+			if _, ok := values[m[1]]; !ok {
+				values[m[1]] = strings.Trim(m[2], `"'`)
+			}
+		}
+	}
+	for _, field := range requiredTemplateFields {
+		if v, ok := values[field]; !ok {
+			problems = append(problems, "missing "+field)
+		} else if len(strings.TrimSpace(v)) == 0 {
+			problems = append(problems, "empty "+field)
+		}
+	}
+
+	return problems
+}
+
+// runTemplateLint renders tmpl against sampleRepoData and prints every
+// problem found, reporting whether the template is clean.
+func runTemplateLint(tmpl *template.Template) bool {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, sampleRepoData()); err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	problems := lintRenderedTemplate(buf.String())
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", *templatePath, p)
+	}
+	return len(problems) == 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var listDeps = flag.String("list-deps", "", "print the package.xml and resulting Void dependencies of this package without writing a template")
+
+// findPackage returns the repository releasing the ROS package name, which is
+// either a repository itself or one of its Release.Packages.
+func findPackage(d DistroData, name string) (*RepoData, bool) {
+	if repodata, ok := d.Repositories[name]; ok {
+		repodata.Name = name
+		return &repodata, true
+	}
+	for reponame, repodata := range d.Repositories {
+		for _, pkg := range repodata.Release.Packages {
+			if pkg == name {
+				repodata.Name = reponame
+				return &repodata, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func printDependencies(kind string, deps []string) {
+	mapped := voidDependencyNames(deps)
+	fmt.Printf("%s:\n\tpackage.xml: %s\n\tvoid:        %s\n", kind, strings.Join(deps, " "), strings.Join(mapped, " "))
+}
+
+func listDependencies(d DistroData, name string) bool {
+	repodata, ok := findPackage(d, name)
+	if !ok {
+		errorf("unknown package %s", name)
+		return false
+	}
+	cleanReleaseVersion(name, repodata)
+
+	sp, err := packageXMLFetcher(repodata)(name)
+	if err != nil {
+		errorf("%v", err)
+		return false
+	}
+
+	fmt.Printf("%s (repository %s, version %s)\n", name, repodata.Name, repodata.Release.Version)
+	printDependencies("hostmakedepends", withHostBaseline(sp.Name, sp.HostMakeDependencies()))
+	printDependencies("makedepends", sp.MakeDependencies())
+	printDependencies("depends", sp.RuntimeDependencies())
+	return true
+}
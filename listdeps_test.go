@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestListDepsRosdep checks that -list-deps shows system dependencies after
+// rosdep mapping.
+func TestListDepsRosdep(t *testing.T) {
+	oldFile, oldList, oldRosdep, oldRules := *distroFilePath, *listDeps, *useRosdep, rosdepRules
+	defer func() { *distroFilePath, *listDeps, *useRosdep, rosdepRules = oldFile, oldList, oldRosdep, oldRules }()
+	*distroFilePath, *listDeps, *useRosdep = "testdata/rosdep/distribution.yaml", "sample_rosdep", true
+	useFetcher(t, rosdepFixture(t))
+
+	var ok bool
+	var err error
+	out := captureStdout(t, func() { ok, err = runDistro(nil) })
+	if err != nil || !ok {
+		t.Fatalf("runDistro = %v, %v:\n%s", ok, err, out)
+	}
+	if !strings.Contains(out, "boost-devel") {
+		t.Errorf("boost not mapped through rosdep:\n%s", out)
+	}
+	if !strings.Contains(out, "python3-PyYAML") {
+		t.Errorf("python-yaml not mapped to the python 3 package:\n%s", out)
+	}
+	if strings.Contains(out, "ros-melodic-boost") {
+		t.Errorf("boost prefixed as a ROS package:\n%s", out)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	lockfile       = flag.String("lockfile", "", "write a sorted TSV of package name, version, tarball URL and checksum to this file")
+	verifyLockfile = flag.Bool("verify-lockfile", false, "fail packages whose checksum differs from the -lockfile entry for the same version")
+)
+
+// lockEntry is one package line of a -lockfile.
+type lockEntry struct {
+	Version    string
+	TarballURL string
+	CheckSum   string
+}
+
+// lockedChecksums holds the -lockfile entries of the previous run by package
+// name when -verify-lockfile is set.
+var lockedChecksums map[string]lockEntry
+
+func loadLockfile(p string) (map[string]lockEntry, error) {
+	entries := map[string]lockEntry{}
+	body, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(body), "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: expected 4 tab-separated fields", p, i+1)
+		}
+		entries[fields[0]] = lockEntry{fields[1], fields[2], fields[3]}
+	}
+	return entries, nil
+}
+
+// checkLockedChecksum reports tampering when pkgname is locked at the same
+// version with a different checksum.
+func checkLockedChecksum(pkgname, version, checksum string) error {
+	locked, ok := lockedChecksums[pkgname]
+	if !ok || locked.Version != version || locked.CheckSum == checksum {
+		return nil
+	}
+	return fmt.Errorf("checksum %s of version %s differs from the locked %s", checksum, version, locked.CheckSum)
+}
+
+// WriteLockfile writes every generated package's lock line to p, keeping the
+// entries of packages this run didn't touch.
+func (s *Summary) WriteLockfile(p string, previous map[string]lockEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := map[string]lockEntry{}
+	for name, e := range previous {
+		entries[name] = e
+	}
+	for _, c := range s.Checksums {
+		entries[c.Name] = lockEntry{c.Version, c.TarballURL, c.CheckSum}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		e := entries[name]
+		fmt.Fprintf(&sb, "%s\t%s\t%s\t%s\n", name, e.Version, e.TarballURL, e.CheckSum)
+	}
+	return writeFileAtomic(p, []byte(sb.String()))
+}
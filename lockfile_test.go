@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteLockfile(t *testing.T) {
+	p := path.Join(t.TempDir(), "lock.tsv")
+	previous := map[string]lockEntry{
+		"untouched": {"0.1.0-0", "https://example.com/untouched.tar.gz", "aaaa"},
+		"roscpp":    {"1.14.2-0", "https://example.com/roscpp-old.tar.gz", "bbbb"},
+	}
+	s := &Summary{Checksums: []ChecksumRecord{
+		{"roscpp", "1.14.3-1", "https://example.com/roscpp.tar.gz", "cccc"},
+		{"actionlib", "1.12.0-0", "https://example.com/actionlib.tar.gz", "dddd"},
+	}}
+	if err := s.WriteLockfile(p, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "actionlib\t1.12.0-0\thttps://example.com/actionlib.tar.gz\tdddd\n" +
+		"roscpp\t1.14.3-1\thttps://example.com/roscpp.tar.gz\tcccc\n" +
+		"untouched\t0.1.0-0\thttps://example.com/untouched.tar.gz\taaaa\n"
+	if string(body) != want {
+		t.Errorf("lockfile:\n%s\nwant:\n%s", body, want)
+	}
+
+	entries, err := loadLockfile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["roscpp"]; !reflect.DeepEqual(got, lockEntry{"1.14.3-1", "https://example.com/roscpp.tar.gz", "cccc"}) {
+		t.Errorf("loaded roscpp = %+v", got)
+	}
+	if len(entries) != 3 {
+		t.Errorf("loaded %d entries, want 3", len(entries))
+	}
+}
+
+func TestCheckLockedChecksum(t *testing.T) {
+	old := lockedChecksums
+	defer func() { lockedChecksums = old }()
+	lockedChecksums = map[string]lockEntry{
+		"roscpp": {"1.14.3-1", "https://example.com/roscpp.tar.gz", "cccc"},
+	}
+
+	tests := []struct {
+		name, pkg, version, checksum string
+		tampered                     bool
+	}{
+		{"unchanged", "roscpp", "1.14.3-1", "cccc", false},
+		{"tampered", "roscpp", "1.14.3-1", "eeee", true},
+		{"new version", "roscpp", "1.14.4-0", "eeee", false},
+		{"not locked", "rospy", "1.14.3-1", "eeee", false},
+	}
+	for _, tt := range tests {
+		err := checkLockedChecksum(tt.pkg, tt.version, tt.checksum)
+		if (err != nil) != tt.tampered {
+			t.Errorf("%s: checkLockedChecksum = %v, want tampered %v", tt.name, err, tt.tampered)
+		}
+	}
+}
+
+// TestVerifyLockfileRecomputes checks that -verify-lockfile hashes the
+// tarball served now rather than trusting a checksum recorded earlier.
+func TestVerifyLockfileRecomputes(t *testing.T) {
+	const url = "https://example.com/roscpp.tar.gz"
+	original := strings.Repeat("original tarball ", 100)
+	tampered := strings.Repeat("tampered tarball ", 100)
+	locked := fmt.Sprintf("%x", sha256.Sum256([]byte(original)))
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"-checksums-in", func(t *testing.T) {
+			old := knownChecksums
+			t.Cleanup(func() { knownChecksums = old })
+			knownChecksums = map[string]string{url: locked}
+		}},
+		{"existing template", func(t *testing.T) {
+			old := outputPath
+			t.Cleanup(func() { outputPath = old })
+			outputPath = t.TempDir()
+			name := currentPrefix() + formatPackageName("roscpp")
+			if err := os.MkdirAll(path.Join(outputDir(), name), 0755); err != nil {
+				t.Fatal(err)
+			}
+			body := fmt.Sprintf("distfiles=\"%s\"\nchecksum=%s\n", url, locked)
+			if err := ioutil.WriteFile(path.Join(outputDir(), name, templateFileName(name, "1.14.3-1")), []byte(body), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"-cache-dir", func(t *testing.T) {
+			old := *cacheDir
+			t.Cleanup(func() { *cacheDir = old })
+			*cacheDir = t.TempDir()
+			storeChecksum(url, locked)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFetcher(t, stubFetcher{url: tampered})
+			oldLocked := lockedChecksums
+			defer func() { lockedChecksums, *verifyLockfile = oldLocked, false }()
+			lockedChecksums = map[string]lockEntry{"roscpp": {"1.14.3-1", url, locked}}
+			*verifyLockfile = true
+			tt.setup(t)
+
+			r := &RepoData{TarballURL: url}
+			r.Release.Version = "1.14.3-1"
+			err := setChecksum("roscpp", r)
+			if err == nil || !strings.Contains(err.Error(), "differs from the locked") {
+				t.Fatalf("setChecksum = %v, want a tampering error", err)
+			}
+			if r.CheckSum == locked {
+				t.Errorf("checksum was reused from %s instead of the download", r.checksumSource)
+			}
+		})
+	}
+}
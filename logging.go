@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+var (
+	verbose = flag.Bool("v", false, "log progress and debug, info and warning messages, including every URL fetched")
+	quiet   = flag.Bool("q", false, "don't print the summary after the run")
+)
+
+// Log levels, from most to least verbose.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// minLevel returns the least severe level logged. By default only
+// per-package errors are, leaving the rest to the summary.
+func minLevel() int {
+	if *verbose {
+		return levelDebug
+	}
+	return levelError
+}
+
+// logf logs at level when it is enabled. The log package writes every line
+// with a single call under its own mutex, so lines from concurrent workers
+// never interleave.
+func logf(level int, format string, args ...interface{}) {
+	if level < minLevel() {
+		return
+	}
+	log.Printf(levelNames[level]+": "+format, args...)
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestLogLevels checks that only errors are logged by default and that -v
+// adds everything else, progress included.
+func TestLogLevels(t *testing.T) {
+	old := *verbose
+	defer func() { *verbose = old }()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, v := range []bool{false, true} {
+		*verbose = v
+		buf.Reset()
+		debugf("fetching sample")
+		infof("generating sample")
+		warnf("sample looks odd")
+		errorf("sample failed")
+		newProgress(1).finish("sample")
+
+		for _, msg := range []string{"fetching sample", "generating sample", "sample looks odd", "[1/1] sample done"} {
+			if got := strings.Contains(buf.String(), msg); got != v {
+				t.Errorf("-v=%v: %q logged = %v, want %v", v, msg, got, v)
+			}
+		}
+		if !strings.Contains(buf.String(), "error: sample failed") {
+			t.Errorf("-v=%v: error not logged:\n%s", v, buf.String())
+		}
+	}
+}
@@ -1,39 +1,398 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v2"
 )
 
 const (
-	pythonVersion  = "3.6"
-	distroListURL  = "https://raw.githubusercontent.com/ros/rosdistro/master/melodic/distribution.yaml"
-	githubRawURL   = "https://raw.githubusercontent.com"
-	outputPath     = "out"
-	goTemplateName = "default.tmpl"
+	githubRawURL = "https://raw.githubusercontent.com"
 )
 
+// outputPath is the directory templates are written to, set with -o.
+var outputPath string
+
+// Person is an <author> or <maintainer> entry from package.xml.
+type Person struct {
+	Name  string `xml:",chardata"`
+	Email string `xml:"email,attr"`
+}
+
+func (p Person) String() string {
+	name := strings.TrimSpace(p.Name)
+	if len(p.Email) > 0 {
+		return fmt.Sprintf("%s <%s>", name, p.Email)
+	}
+	return name
+}
+
 type SubPackage struct {
-	Name              string   `xml:"name"`
-	Description       string   `xml:"description"`
-	BuildDependencies []string `xml:"buildtool_depend"`
-	RunDependencies   []string `xml:"run_depend"`
+	Format                      string   `xml:"format,attr"`
+	Name                        string   `xml:"name"`
+	Description                 string   `xml:"description"`
+	BuildDependencies           []string `xml:"buildtool_depend"`
+	BuildToolExportDependencies []string `xml:"buildtool_export_depend"`
+	RunDependencies             []string `xml:"run_depend"`
+	Authors                     []Person `xml:"author"`
+	Licenses                    []string `xml:"license"`
+	BuildType                   string   `xml:"export>build_type"`
+
+	// Format 2 and 3 dependency tags. Once parsed, <depend> is added to
+	// BuildDepends and the run dependencies among them to RunDependencies.
+	// A format 1 build_depend is read into BuildDepends as well.
+	BuildDepends       []string `xml:"build_depend"`
+	BuildExportDepends []string `xml:"build_export_depend"`
+	ExecDepends        []string `xml:"exec_depend"`
+	Depends            []string `xml:"depend"`
+
+	// Versions maps dependencies pinned with version_* attributes to their
+	// xbps constraint, e.g. ">=1.2.3".
+	Versions map[string]string `xml:"-"`
+
+	// Siblings are the sub-packages rendered into the same template.
+	Siblings map[string]bool `xml:"-"`
+}
+
+// xmlDependency is any package.xml element, read for the version attributes
+// dependency tags may carry.
+type xmlDependency struct {
+	XMLName    xml.Name
+	Name       string `xml:",chardata"`
+	VersionLt  string `xml:"version_lt,attr"`
+	VersionLte string `xml:"version_lte,attr"`
+	VersionEq  string `xml:"version_eq,attr"`
+	VersionGte string `xml:"version_gte,attr"`
+	VersionGt  string `xml:"version_gt,attr"`
+}
+
+// constraint renders the version attributes as an xbps pattern suffix. Void
+// can't pin an upstream version exactly without knowing its revision, so
+// version_eq becomes a lower bound.
+func (d xmlDependency) constraint() string {
+	var c string
+	switch {
+	case len(d.VersionEq) > 0:
+		c = ">=" + d.VersionEq
+	case len(d.VersionGte) > 0:
+		c = ">=" + d.VersionGte
+	case len(d.VersionGt) > 0:
+		c = ">" + d.VersionGt
+	}
+	switch {
+	case len(d.VersionEq) > 0:
+	case len(d.VersionLte) > 0:
+		c += "<=" + d.VersionLte
+	case len(d.VersionLt) > 0:
+		c += "<" + d.VersionLt
+	}
+	return c
+}
+
+// trimSpace strips the whitespace XML elements are often padded with, which
+// would otherwise end up inside package names.
+func (sp *SubPackage) trimSpace() {
+	sp.Name = strings.TrimSpace(sp.Name)
+	sp.Format = strings.TrimSpace(sp.Format)
+	sp.BuildType = strings.TrimSpace(sp.BuildType)
+	for _, deps := range []*[]string{
+		&sp.BuildDependencies, &sp.BuildToolExportDependencies, &sp.RunDependencies,
+		&sp.BuildDepends, &sp.BuildExportDepends, &sp.ExecDepends, &sp.Depends,
+	} {
+		trimmed := (*deps)[:0]
+		for _, dep := range *deps {
+			if dep = strings.TrimSpace(dep); len(dep) > 0 {
+				trimmed = append(trimmed, dep)
+			}
+		}
+		*deps = trimmed
+	}
+}
+
+// foldDependencies merges the format 2 and 3 tags into the build and run
+// lists the rest of the generator reads. <depend> is both, and like bloom,
+// build_export_depend and exec_depend become run dependencies.
+func (sp *SubPackage) foldDependencies() {
+	sp.BuildDepends = append(sp.BuildDepends, sp.Depends...)
+	for _, deps := range [][]string{sp.BuildExportDepends, sp.ExecDepends, sp.Depends} {
+		sp.RunDependencies = append(sp.RunDependencies, deps...)
+	}
+}
+
+// parseVersionConstraints fills sp.Versions from the package.xml in body.
+func (sp *SubPackage) parseVersionConstraints(body []byte) error {
+	var pkg struct {
+		Elements []xmlDependency `xml:",any"`
+	}
+	if err := xml.Unmarshal(body, &pkg); err != nil {
+		return err
+	}
+	for _, e := range pkg.Elements {
+		if !strings.HasSuffix(e.XMLName.Local, "depend") {
+			continue
+		}
+		if c := e.constraint(); len(c) > 0 {
+			if sp.Versions == nil {
+				sp.Versions = map[string]string{}
+			}
+			sp.Versions[strings.TrimSpace(e.Name)] = c
+		}
+	}
+	return nil
+}
+
+// Pinned appends the version constraint of each dependency in deps that has
+// one.
+func (sp *SubPackage) Pinned(deps []string) []string {
+	pinned := make([]string, len(deps))
+	for i, dep := range deps {
+		pinned[i] = dep + sp.Versions[dep]
+	}
+	return pinned
+}
+
+// buildStyles maps package.xml build types to Void build styles.
+var buildStyles = map[string]string{
+	"catkin":       "cmake",
+	"cmake":        "cmake",
+	"ament_cmake":  "cmake",
+	"ament_python": "python3-module",
+}
+
+// BuildStyle is the Void build_style for the build type package.xml exports,
+// or -build-style when it exports none or an unknown one.
+func (sp *SubPackage) BuildStyle() string {
+	if style, ok := buildStyles[sp.BuildType]; ok {
+		return style
+	}
+	return *defaultBuildStyle
+}
+
+// IsCatkin reports whether the package builds with catkin, which format 1
+// packages always do.
+func (sp *SubPackage) IsCatkin() bool {
+	return len(sp.BuildType) == 0 || sp.BuildType == "catkin"
+}
+
+// FormerNames are the Void packages sp replaces after a rename.
+func (sp *SubPackage) FormerNames() []string {
+	return settings.Renames[sp.Name]
+}
+
+// BuildTimeDependencies returns every dependency needed at build time, on
+// the host or the target.
+func (sp *SubPackage) BuildTimeDependencies() []string {
+	deps := append([]string{}, sp.BuildDependencies...)
+	deps = append(deps, sp.BuildToolExportDependencies...)
+	return append(deps, sp.BuildDepends...)
+}
+
+// HostMakeDependencies are what runs on the build host for hostmakedepends=:
+// the buildtool dependencies and every host tool among the build and run
+// dependencies. Siblings are left out as they come out of the same build.
+func (sp *SubPackage) HostMakeDependencies() []string {
+	var deps []string
+	for _, list := range [][]string{sp.BuildDependencies, sp.BuildToolExportDependencies} {
+		for _, dep := range list {
+			if !sp.Siblings[dep] {
+				deps = append(deps, dep)
+			}
+		}
+	}
+	for _, list := range [][]string{sp.BuildDepends, sp.RunDependencies} {
+		for _, dep := range list {
+			if isHostTool(dep) {
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return deps
+}
+
+// MakeDependencies are the build_depend and depend libraries built against
+// for makedepends=. Host tools go to hostmakedepends= instead.
+func (sp *SubPackage) MakeDependencies() []string {
+	var deps []string
+	for _, dep := range sp.BuildDepends {
+		if !sp.Siblings[dep] && !isHostTool(dep) && dep != sp.Name {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// RuntimeDependencies are the run dependencies without host tools.
+func (sp *SubPackage) RuntimeDependencies() []string {
+	var deps []string
+	for _, dep := range sp.RunDependencies {
+		if !isHostTool(dep) && dep != sp.Name {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// aggregateDependencies merges the dependencies each sub-package reports,
+// leaving out the repository's own sub-packages. Repeats within one
+// sub-package are always dropped, repeats across sub-packages unless
+// -dedupe-across-subpackages=false asks for the union of their lists.
+func (r *RepoData) aggregateDependencies(deps func(*SubPackage) []string) []string {
+	own := map[string]bool{}
+	for _, sp := range r.SubPackages {
+		own[sp.Name] = true
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, sp := range r.SubPackages {
+		if !*dedupeSubpackages {
+			seen = map[string]bool{}
+		}
+		for _, dep := range deps(sp) {
+			if own[dep] || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			out = append(out, dep)
+		}
+	}
+	return out
+}
+
+// HostMakeDependencies are the build dependencies of every sub-package.
+func (r *RepoData) HostMakeDependencies() []string {
+	return r.aggregateDependencies((*SubPackage).HostMakeDependencies)
+}
+
+// SubPackageNames are the Void names of the sub-packages after the first,
+// each rendered as a <name>_package() function of the same template. It is
+// empty for single-package repositories.
+func (r *RepoData) SubPackageNames() []string {
+	var names []string
+	for i, sp := range r.SubPackages {
+		if i > 0 {
+			names = append(names, currentPrefix()+formatPackageName(sp.Name))
+		}
+	}
+	return names
+}
+
+// Provides are the Void packages the srcpkg stands in for: the former names
+// of its first sub-package and, as the srcpkg is named after the repository,
+// that sub-package's own name when it differs.
+func (r *RepoData) Provides() []string {
+	if len(r.SubPackages) == 0 {
+		return nil
+	}
+	first := r.SubPackages[0]
+	provides := append([]string{}, first.FormerNames()...)
+	if formatPackageName(first.Name) != formatPackageName(r.Name) {
+		provides = append(provides, currentPrefix()+formatPackageName(first.Name))
+	}
+	return provides
+}
+
+// MakeDependencies are the target build dependencies of every sub-package.
+func (r *RepoData) MakeDependencies() []string {
+	return r.aggregateDependencies((*SubPackage).MakeDependencies)
+}
+
+// Pinned is SubPackage.Pinned with the version constraints of every
+// sub-package, for the lists the whole srcpkg shares.
+func (r *RepoData) Pinned(deps []string) []string {
+	pinned := make([]string, len(deps))
+	for i, dep := range deps {
+		pinned[i] = dep
+		for _, sp := range r.SubPackages {
+			if c, ok := sp.Versions[dep]; ok {
+				pinned[i] = dep + c
+				break
+			}
+		}
+	}
+	return pinned
+}
+
+// RuntimeDependencies are the run dependencies of every sub-package.
+func (r *RepoData) RuntimeDependencies() []string {
+	return r.aggregateDependencies((*SubPackage).RuntimeDependencies)
+}
+
+// Distfile is one entry of distfiles= with the checksum= entry at the same
+// position.
+type Distfile struct {
+	URL      string
+	CheckSum string
+}
+
+// Distfiles pairs every URL the template fetches with its checksum. All
+// sub-packages build from the one release tarball, so there is a single entry.
+func (r *RepoData) Distfiles() []Distfile {
+	return []Distfile{{r.TarballURL, r.CheckSum}}
+}
+
+// PackageWrksrc returns the directory of sub-package name inside the
+// extracted tarball. Bloom tags every released package with its package.xml at
+// the root of the archive, while source archives hold a package in its own
+// directory.
+func (r *RepoData) PackageWrksrc(name string) string {
+	if len(r.Release.URL) > 0 {
+		return r.Wrksrc
+	}
+	dir := name
+	if p, ok := packagePathOverrides[name]; ok {
+		dir = path.Dir(p)
+	}
+	if dir == "." {
+		return r.Wrksrc
+	}
+	return r.Wrksrc + "/" + dir
+}
+
+// distfileURLs and distfileSums render the two sides of a Distfiles list, one
+// entry per line, so checksum= always lists its sums in distfiles= order.
+func distfileURLs(files []Distfile) string {
+	urls := make([]string, len(files))
+	for i, f := range files {
+		urls[i] = escapeQuoted(f.URL)
+	}
+	return strings.Join(urls, "\n ")
 }
 
+func distfileSums(files []Distfile) string {
+	sums := make([]string, len(files))
+	for i, f := range files {
+		sums[i] = f.CheckSum
+	}
+	return strings.Join(sums, "\n ")
+}
+
+// RepoData is the data every template is rendered from. Rendering it is
+// deterministic: its lists keep the order of distribution.yaml and
+// package.xml, and text/template ranges over maps such as Release.Tags and
+// Vars in sorted key order.
 type RepoData struct {
 	// From distribution.yaml
 	Name string
@@ -56,11 +415,27 @@ type RepoData struct {
 	Status string
 
 	// From package.xml
-	SubPackages []*SubPackage
+	SubPackages []*SubPackage `yaml:"-"`
 
 	// Custom
+	Distro     *Distro `yaml:"-"`
 	TarballURL string
 	CheckSum   string
+	Vars       map[string]string `yaml:"-"`
+
+	// Wrksrc is the top-level directory the tarball extracts to.
+	Wrksrc string `yaml:"-"`
+
+	// Stamp is set when -stamp asks for a provenance header.
+	Stamp *Stamp `yaml:"-"`
+
+	// UpstreamVersion and ReleaseIncrement split Release.Version, e.g.
+	// "1.2.3" and "1" for "1.2.3-1".
+	UpstreamVersion  string `yaml:"-"`
+	ReleaseIncrement string `yaml:"-"`
+
+	// checksumSource tells where CheckSum came from, for -explain.
+	checksumSource string
 }
 
 type DistroData struct {
@@ -73,226 +448,2018 @@ type DistroData struct {
 	Repositories map[string]RepoData
 	Version      string
 	Type         string
+
+	// Checksum is the sha256 of the distribution files read.
+	Checksum string `yaml:"-"`
 }
 
 type Settings struct {
 	Maintainer string
-}
 
-func Error(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
+	// BaselineMakeDepends are injected into every template's build
+	// dependencies on top of what package.xml declares.
+	BaselineMakeDepends []string `yaml:"baseline_makedepends"`
+
+	// HostMakeDepends are Void packages put in every template's
+	// hostmakedepends. HostTools are package.xml dependencies naming such
+	// host tools, which go to hostmakedepends under their own name instead
+	// of being treated as ROS packages.
+	HostMakeDepends []string `yaml:"hostmakedepends"`
+	HostTools       []string `yaml:"host_tools"`
+
+	// ShortDescriptions replace the package.xml description of the named
+	// packages, for upstream descriptions that are missing or useless.
+	ShortDescriptions map[string]string `yaml:"short_desc"`
+
+	// DepsSeparator joins dependencies sharing a line and DepsLead starts
+	// every continuation line of a dependency list after its indent.
+	DepsSeparator string `yaml:"deps_separator"`
+	DepsLead      string `yaml:"deps_lead"`
+
+	// Renames maps ROS packages to the Void packages they were formerly
+	// published as, which their templates provide and replace.
+	Renames map[string][]string `yaml:"renames"`
 }
 
-func formatPackageName(s string) string {
-	s = strings.ReplaceAll(s, "_", "-")
-	return s
+var settings = Settings{
+	BaselineMakeDepends: []string{"catkin"},
+	HostMakeDepends:     []string{"cmake", "python3"},
+	DepsSeparator:       " ",
+	DepsLead:            " ",
 }
 
-func formatVersionString(s string) string {
-	s = strings.ReplaceAll(s, "-", "_")
-	s = strings.ReplaceAll(s, ":", "_")
-	return s
+func isHostTool(dep string) bool {
+	for _, lists := range [][]string{settings.HostMakeDepends, settings.HostTools} {
+		for _, tool := range lists {
+			if tool == dep {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func formatDescription(s string) string {
-	s = strings.Trim(s, " .\n")
-	if len(s)+6 >= 72 {
-		s = s[0:62] + "..."
+func loadSettings(p string) error {
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
 	}
-	return s
+	return yaml.Unmarshal(body, &settings)
 }
 
-func formatDependencyList(ss []string, offset, indent int, first bool) string {
-	var sb strings.Builder
-	// col starts out at 9 because we assume it's used in `depends=`
-	col := offset
+// pruneMode is the value of -prune: empty when disabled, "dry" to only report
+// stale directories and "true" to remove them.
+type pruneMode string
 
-	ignoreList := map[string]bool{
-		"cmake":   true,
-		"python3": true,
-		"python":  true,
-		"catkin":  true,
+func (m *pruneMode) String() string   { return string(*m) }
+func (m *pruneMode) IsBoolFlag() bool { return true }
+
+func (m *pruneMode) Set(s string) error {
+	switch s {
+	case "true", "dry":
+		*m = pruneMode(s)
+	case "false":
+		*m = ""
+	default:
+		return errors.New("must be true, false or dry")
 	}
+	return nil
+}
 
-	for _, s := range ss {
-		if _, ok := ignoreList[s]; ok {
-			continue
-		}
+// stringList is a repeatable flag that also accepts comma-separated values.
+type stringList []string
 
-		s := "ros-melodic-" + formatPackageName(s)
-		if col+len(s)+1 > 100 {
-			sb.WriteString("\n")
-			col = 1
-			if indent == 1 {
-				sb.WriteString("\t")
-				col += 2
-			}
-		}
-		if !first {
-			sb.WriteString(" ")
-			col++
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			*l = append(*l, v)
 		}
-		first = false
-		sb.WriteString(s)
-		col += len(s)
 	}
-	return sb.String()
+	return nil
 }
 
-func getHTTPResponseBody(url string) ([]byte, error) {
-	resp, err := http.Get(url)
-	defer resp.Body.Close()
-	if err != nil {
-		return nil, err
+// varMap is a repeatable key=value flag.
+type varMap map[string]string
+
+func (m varMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m varMap) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+		return fmt.Errorf("%q is not key=value", s)
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	m[strings.TrimSpace(kv[0])] = kv[1]
+	return nil
+}
+
+// blacklist holds the packages named in -blacklist, which are never
+// generated.
+var blacklist map[string]bool
+
+// loadNameList reads one package name per line, ignoring blank lines and
+// # comments.
+func loadNameList(p string) (map[string]bool, error) {
+	body, err := ioutil.ReadFile(p)
 	if err != nil {
 		return nil, err
 	}
+	names := map[string]bool{}
+	for _, line := range strings.Split(string(body), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			names[line] = true
+		}
+	}
+	return names, nil
+}
+
+// knownChecksums maps tarball URLs to checksums loaded with -checksums-in.
+var knownChecksums map[string]string
+
+var (
+	prune        pruneMode
+	packageNames stringList
+	distros      stringList
+	templateVars = varMap{}
+
+	validateURLs      = flag.Bool("validate-checksum-urls", false, "check that every tarball URL is reachable without downloading it")
+	maxDescription    = flag.Int("max-desc", 72, "maximum short_desc length, 0 disables truncation")
+	stateFile         = flag.String("state", "", "file recording generated package versions, used to resume interrupted runs")
+	force             = flag.Bool("force", false, "regenerate packages even if they are already up to date, and download tarballs whose checksum the existing template holds")
+	configFile        = flag.String("config", "", "YAML file with generator settings")
+	checksumsOut      = flag.String("checksums-out", "", "write a TSV of package name, tarball URL and checksum to this file")
+	splitSubpackages  = flag.Bool("split-subpackages", false, "emit a separate template for every sub-package of a repository")
+	overridesDir      = flag.String("overrides", "", "directory of hand-maintained <prefix><pkg>.template files copied instead of generating")
+	includeAuthors    = flag.Bool("include-authors", false, "list package.xml authors in a comment at the top of each template")
+	checksumsIn       = flag.String("checksums-in", "", "TSV in the -checksums-out format whose checksums are used instead of downloading those tarballs")
+	metapackage       = flag.String("metapackage", "", "after a full run, also write a metapackage template with this name depending on every generated package")
+	proxyURL          = flag.String("proxy", "", "HTTP proxy URL, overriding HTTP_PROXY/HTTPS_PROXY from the environment")
+	verifyTarball     = flag.Bool("verify-tarball", false, "decompress every downloaded tarball to catch corrupt downloads before checksumming")
+	packagePrefix     = flag.String("prefix", "", "prefix of generated package names and of dependencies on other ROS packages (default \"ros-<distro>-\")")
+	summaryJSON       = flag.String("summary-json", "", "write counts of generated, skipped and failed packages to this JSON file")
+	forceLF           = flag.Bool("normalize-line-endings", false, "write templates with LF line endings regardless of the template file")
+	metadataOnly      = flag.Bool("metadata-only", false, "skip tarball downloads and write a placeholder checksum, for auditing dependencies quickly")
+	changelogFile     = flag.String("changelog", "", "write the packages whose version changed since the -state file, and those added, to this file")
+	minTarballBytes   = flag.Int("min-tarball-bytes", 1024, "reject downloaded tarballs smaller than this as likely error pages")
+	normalizeDesc     = flag.Bool("normalize-desc", false, "capitalize the first letter of short_desc unless it starts with an acronym or mixed-case name")
+	dedupeSubpackages = flag.Bool("dedupe-across-subpackages", true, "drop dependencies repeated across sub-packages from the repository-wide .HostMakeDependencies, .MakeDependencies and .RuntimeDependencies lists; rendered dependency lists never repeat a dependency")
+	templateFilename  = flag.String("template-filename", "template", "name of the file written in each package directory; {pkg} and {version} are replaced")
+	fromStdin         = flag.Bool("from-stdin", false, "also generate the newline-separated package names read from stdin, like repeated -p")
+	onlyInvalid       = flag.Bool("only-invalid", false, "regenerate only the packages that failed in the previous -summary-json report")
+	allowInsecure     = flag.Bool("allow-insecure", false, "allow fetching plain http URLs")
+	depsStyle         = flag.String("deps-style", "wrapped", "layout of dependency lists: wrapped at 100 columns, oneline, or multiline with one dependency per line")
+	minDescription    = flag.Int("min-desc", 0, "warn about descriptions shorter than this many characters, 0 disables the check")
+	strict            = flag.Bool("strict", false, "fail packages over quality warnings such as -min-desc instead of only reporting them")
+	tarballURLPattern = flag.String("tarball-url", "", "tarball URL pattern used instead of the release repository forge's archive of the release tag; {url}, {distro}, {name}, {version} and {upstream_version} are replaced")
+	blacklistFile     = flag.String("blacklist", "", "file of package names, one per line, that are never generated")
+	allowSource       = flag.Bool("allow-source", false, "generate repositories without a release from the forge archive of their source version")
+	defaultBuildStyle = flag.String("build-style", "cmake", "Void build_style of packages whose package.xml declares no build_type")
+	jobs              = flag.Int("j", 8, "number of repositories processed at once; 1 processes them serially")
+	distroFilePath    = flag.String("distro-file", "", "read the distribution.yaml from this local file instead of fetching it from rosdistro")
+	templatePath      = flag.String("template", "default.tmpl", "path of the Go template templates are rendered from")
+)
 
-	return body, nil
+func init() {
+	flag.Var(&distros, "distro", "ROS distro to generate; may be repeated or comma-separated")
+	flag.Var(&packageNames, "p", "package name; may be repeated or comma-separated")
+	// Vars are only reachable as .Vars.<key>, so they can never shadow a
+	// RepoData field such as .Name or .CheckSum.
+	flag.Var(templateVars, "var", "extra template variable exposed as .Vars.<key>; key=value, may be repeated")
+	flag.Var(&prune, "prune", "remove output directories of packages no longer in the distribution (-prune=dry only reports them)")
+	flag.BoolVar(onlyInvalid, "retry-failed", false, "same as -only-invalid")
+	flag.StringVar(&outputPath, "o", "out", "output directory, e.g. the srcpkgs directory of a void-packages clone")
+	flag.StringVar(&outputPath, "out", "out", "same as -o")
 }
 
-func getPackageList() DistroData {
-	d := DistroData{}
+var (
+	invalidPackageNameChars = regexp.MustCompile(`[^a-z0-9+-]+`)
+	normalizedPackageNames  sync.Map
+)
 
-	body, err := getHTTPResponseBody(distroListURL)
-	Error(err)
+// formatPackageName turns a ROS package name into a valid Void pkgname. Names
+// that need more than the usual "_" to "-" substitution are logged once so
+// they can be checked by hand.
+func formatPackageName(s string) string {
+	hyphenated := strings.ReplaceAll(s, "_", "-")
+	name := invalidPackageNameChars.ReplaceAllString(strings.ToLower(hyphenated), "-")
+	if name != hyphenated {
+		if _, logged := normalizedPackageNames.LoadOrStore(s, true); !logged {
+			infof("normalized package name %q to %q", s, name)
+		}
+	}
+	return name
+}
 
-	err = yaml.Unmarshal(body, &d)
-	Error(err)
+// formatVersionString turns a bloom release version such as "1:1.2.3~rc1-0"
+// into the version= of its template. The release increment becomes the
+// revision, xbps has no epochs, and dropping a "~" leaves pre-releases such as
+// "rc1", which xbps already sorts before the release.
+func formatVersionString(s string) (string, error) {
+	version, _ := splitReleaseVersion(s)
+	if i := strings.Index(version, ":"); i >= 0 {
+		version = version[i+1:]
+	}
+	version = strings.ReplaceAll(version, "~", "")
+	if len(version) == 0 {
+		return "", fmt.Errorf("release version %q has no upstream version", s)
+	}
+	for _, r := range version {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(".+", r) {
+			return "", fmt.Errorf("version %q of release %q contains %q, which xbps doesn't allow", version, s, r)
+		}
+	}
+	return version, nil
+}
 
-	return d
+// formatRevision returns the revision= for a bloom release version: its
+// release increment, which counts from 0, plus one. Anything after the
+// increment's digits, such as a codename, is ignored.
+func formatRevision(s string) int {
+	_, increment := splitReleaseVersion(s)
+	n := 0
+	for _, r := range increment {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = 10*n + int(r-'0')
+	}
+	return n + 1
 }
 
-func parseGoTemplate() *template.Template {
-	t, err := template.New(goTemplateName).Funcs(
-		template.FuncMap{
-			"fmt":        formatPackageName,
-			"fmtDesc":    formatDescription,
-			"fmtVersion": formatVersionString,
-			"fmtList":    formatDependencyList,
-		},
-	).ParseFiles(goTemplateName)
-	Error(err)
-	return t
+// Codenames that bloom occasionally leaves on the end of a release version,
+// e.g. "1.14.3-1bionic".
+var ubuntuCodenames = []string{
+	"trusty", "xenial", "artful", "bionic", "cosmic", "disco", "eoan", "focal",
 }
 
-func openVoidTemplateFile(name string) *os.File {
-	p := path.Join(outputPath, name)
-	if _, err := os.Stat(p); os.IsNotExist(err) {
-		os.Mkdir(p, os.ModePerm)
+// splitDistroSuffix separates a trailing Ubuntu codename from version.
+func splitDistroSuffix(version string) (string, string) {
+	for _, codename := range ubuntuCodenames {
+		if strings.HasSuffix(version, codename) {
+			return strings.TrimSuffix(version, codename), codename
+		}
 	}
+	return version, ""
+}
 
-	f, err := os.Create(path.Join(p, "template"))
-	Error(err)
+func cleanReleaseVersion(pkgname string, repodata *RepoData) {
+	version, suffix := splitDistroSuffix(repodata.Release.Version)
+	if len(suffix) > 0 {
+		infof("%s: stripped %q from release version %s", pkgname, suffix, repodata.Release.Version)
+		repodata.Release.Version = version
+	}
+}
 
-	return f
+// formatDescription collapses the whitespace of s onto one line and trims it
+// so that "ROS - " plus s stays below max characters, cutting at the last
+// word that fits. A max of 0 disables truncation.
+func formatDescription(s string, max int) string {
+	s = strings.Trim(strings.Join(strings.Fields(s), " "), " .")
+	if max > 0 && utf8.RuneCountInString(s)+6 >= max {
+		n := max - 10
+		if n < 0 {
+			n = 0
+		}
+		cut := string([]rune(s)[:n])
+		if i := strings.LastIndex(cut, " "); i > 0 {
+			cut = cut[:i]
+		}
+		s = strings.TrimRight(cut, " ,.;:") + "..."
+	}
+	return s
 }
 
-func getGithubRepoFromURL(url string) (string, error) {
-	re := regexp.MustCompile(`([^/]+/[^/^.]+)\.git?`)
-	if re.MatchString(url) {
-		return string(re.FindStringSubmatch(url)[1]), nil
-	} else {
-		return "", errors.New("Not a correct github URL.")
+func formatShortDescription(s string) string {
+	if *normalizeDesc {
+		s = capitalizeDescription(s)
 	}
+	return formatDescription(s, *maxDescription)
 }
 
-func getPackageXML(name, version, url string) (*SubPackage, error) {
-	githubRepo, err := getGithubRepoFromURL(url)
-	if err != nil {
-		return nil, err
+// capitalizeDescription upper-cases the first letter of s unless its first
+// word already contains capitals, which keeps names like "ROS" or "eProsima"
+// as they are.
+func capitalizeDescription(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || strings.ToLower(fields[0]) != fields[0] {
+		return s
 	}
+	s = strings.TrimLeft(s, " \t\n")
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
 
-	sp := &SubPackage{}
-	rawurl := fmt.Sprintf("%s/%s/%s/%s/package.xml", githubRawURL, githubRepo, version, name)
-	body, err := getHTTPResponseBody(rawurl)
-	xml.Unmarshal(body, sp)
+func currentPrefix() string {
+	return distro.Prefix()
+}
 
-	return sp, err
+func authorsEnabled() bool {
+	return *includeAuthors
 }
 
-func getTarballURL(name, version, url string) string {
-	return fmt.Sprintf(
-		"%s/archive/release/%s/%s/%s.tar.gz",
-		strings.ReplaceAll(url, ".git", ""),
-		"melodic",
-		name,
-		version,
+// escapeQuoted escapes the characters that are special inside a double-quoted
+// shell string so that values from package.xml can't break the template.
+func escapeQuoted(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"`", "\\`",
+		`$`, `\$`,
 	)
+	return r.Replace(s)
 }
 
-func getTarballChecksum(url string) (string, error) {
-	body, err := getHTTPResponseBody(url)
-	if err != nil {
-		return "", err
+// withBaseline prepends the configured baseline build dependencies to deps,
+// dropping duplicates and pkgname itself.
+func withBaseline(pkgname string, deps []string) []string {
+	seen := map[string]bool{pkgname: true}
+	var out []string
+	for _, list := range [][]string{settings.BaselineMakeDepends, deps} {
+		for _, dep := range list {
+			if !seen[dep] {
+				seen[dep] = true
+				out = append(out, dep)
+			}
+		}
 	}
+	return out
+}
 
-	return fmt.Sprintf("%x", sha256.Sum256(body)), nil
+// withHostBaseline puts the configured hostmakedepends in front of
+// withBaseline(pkgname, deps).
+func withHostBaseline(pkgname string, deps []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, list := range [][]string{settings.HostMakeDepends, withBaseline(pkgname, deps)} {
+		for _, dep := range list {
+			if !seen[dep] {
+				seen[dep] = true
+				out = append(out, dep)
+			}
+		}
+	}
+	return out
 }
 
-func prepareAdditionalPackageData(pkgname string, repodata *RepoData) error {
-	var pkgxml *SubPackage
-	var err error
+// ignoreList holds dependencies that are either provided by the template
+// itself or aren't ROS packages, so they never get the package prefix.
+var ignoreList = map[string]bool{
+	"cmake":   true,
+	"python3": true,
+	"python":  true,
+}
 
-	if len(repodata.Release.Packages) == 0 {
-		pkgxml, err = getPackageXML(pkgname, repodata.Source.Version, repodata.Source.URL)
-		repodata.SubPackages = append(repodata.SubPackages, pkgxml)
-	} else {
-		for _, subpkgname := range repodata.Release.Packages {
-			pkgxml, err = getPackageXML(subpkgname, repodata.Source.Version, repodata.Source.URL)
-			repodata.SubPackages = append(repodata.SubPackages, pkgxml)
+// indentWidth returns how many columns indent occupies, counting a tab as a
+// full eight-column stop.
+func indentWidth(indent string) int {
+	width := 0
+	for _, r := range indent {
+		if r == '\t' {
+			width += 8 - width%8
+		} else {
+			width++
 		}
 	}
+	return width
+}
 
-	return err
+// voidDependencyName maps a package.xml dependency to the Void package it
+// becomes, reporting false for dependencies in ignoreList. Host tools keep
+// their own name.
+func voidDependencyName(dep string) (string, bool) {
+	if isHostTool(dep) {
+		return dep, true
+	}
+	if ignoreList[dep] {
+		return "", false
+	}
+	return currentPrefix() + formatPackageName(dep), true
 }
 
-func generateTemplate(pkgname string, repodata *RepoData, tmpl *template.Template) {
-	var err error
-	repodata.Name = pkgname
-	repodata.TarballURL = getTarballURL(pkgname, repodata.Release.Version, repodata.Release.URL)
-	println(repodata.TarballURL)
-	repodata.CheckSum, err = getTarballChecksum(repodata.TarballURL)
-	Error(err)
-
-	if len(repodata.Release.URL) > 0 {
-		err := prepareAdditionalPackageData(pkgname, repodata)
-		if err == nil {
-			f := openVoidTemplateFile("ros-melodic-" + formatPackageName(pkgname))
-			err = tmpl.ExecuteTemplate(f, goTemplateName, repodata)
-			if err != nil {
-				println("ERROR AT " + pkgname)
-				Error(err)
+// splitVersionConstraint separates a dependency such as "roscpp>=1.2" into
+// its name and constraint.
+func splitVersionConstraint(dep string) (string, string) {
+	if i := strings.IndexAny(dep, "<>="); i >= 0 {
+		return dep[:i], dep[i:]
+	}
+	return dep, ""
+}
+
+// voidDependencyNames maps ss to Void package names with any version
+// constraint kept, leaving out ignored dependencies.
+func voidDependencyNames(ss []string) []string {
+	var names []string
+	for _, s := range ss {
+		name, constraint := splitVersionConstraint(s)
+		if pkgs, ok := rosdepPackages(name); ok {
+			names = append(names, pkgs...)
+			continue
+		}
+		mapped, ok := voidDependencyName(name)
+		if !ok {
+			continue
+		}
+		if !isHostTool(name) {
+			mapped += constraint
+		}
+		names = append(names, mapped)
+	}
+	return names
+}
+
+// sortedDependencyNames sorts names and drops repeats. A name listed both
+// with and without a version constraint is kept once, with the constraint.
+func sortedDependencyNames(names []string) []string {
+	byName := map[string]string{}
+	for _, s := range names {
+		name, constraint := splitVersionConstraint(s)
+		if old, ok := byName[name]; !ok || (len(constraint) > 0 && old == name) {
+			byName[name] = s
+		}
+	}
+	out := make([]string, 0, len(byName))
+	for _, s := range byName {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// formatDependencyList renders ss in the -deps-style layout. offset is the
+// column the list starts at, indent begins continuation lines and first
+// tells whether the list opens the value or follows other text. Dependencies
+// sharing a line are joined by the configured separator and continuation
+// lines start with the configured lead after indent. The mapped names are
+// sorted and deduplicated so output doesn't churn between runs.
+func formatDependencyList(ss []string, offset int, indent string, first bool) string {
+	sep, lead := settings.DepsSeparator, settings.DepsLead
+	var sb strings.Builder
+	col := offset
+	for _, s := range sortedDependencyNames(voidDependencyNames(ss)) {
+		var wrap bool
+		switch *depsStyle {
+		case "multiline":
+			wrap = !first
+		case "wrapped":
+			wrap = !first && col+len(sep)+len(s) > 100
+		}
+		if wrap {
+			if *depsStyle == "multiline" {
+				sb.WriteString(" \\")
 			}
+			sb.WriteString("\n")
+			sb.WriteString(indent)
+			sb.WriteString(lead)
+			col = indentWidth(indent + lead)
+		} else if !first {
+			sb.WriteString(sep)
+			col += len(sep)
 		}
+		first = false
+		sb.WriteString(s)
+		col += len(s)
 	}
+	return sb.String()
 }
 
-func main() {
-	d := getPackageList()
-	t := parseGoTemplate()
+// Fetcher sends HTTP requests. *http.Client is one; anything else answering
+// requests, such as a stub serving fixtures, can stand in for it.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
 
-	name := flag.String("p", "", "package name")
-	flag.Parse()
+// httpClient is shared by every request so transport settings such as the
+// proxy apply to all of them. Every fetch helper, from getPackageList to
+// getTarballChecksum, goes through it.
+var httpClient Fetcher = http.DefaultClient
 
-	if len(*name) == 0 {
-		var wg sync.WaitGroup
-		wg.Add(len(d.Repositories))
-		for pkgname, repodata := range d.Repositories {
-			go func(pkgname string, repodata RepoData) {
-				generateTemplate(pkgname, &repodata, t)
-				wg.Done()
-			}(pkgname, repodata)
-		}
-		wg.Wait()
-	} else {
-		println("Single Mode: generating " + *name)
-		if repodata, ok := d.Repositories[*name]; ok {
-			generateTemplate(*name, &repodata, t)
+// newHTTPClient builds a client whose transport goes through proxy, or
+// through HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment when proxy is
+// empty.
+func newHTTPClient(proxy string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if len(proxy) > 0 {
+		u, err := neturl.Parse(proxy)
+		if err != nil {
+			return nil, err
 		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	var rt http.RoundTripper = transport
+	if *traceHTTP {
+		rt = &tracingTransport{transport}
+	}
+	return &http.Client{Transport: rt, CheckRedirect: checkRedirect, Timeout: *httpTimeout}, nil
+}
+
+// checkRedirect follows redirects within a host and to the host a forge
+// hands its archive downloads to, and refuses any other.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if err := checkSecureURL(req.URL.String()); err != nil {
+		return err
+	}
+	from, to := via[len(via)-1].URL.Hostname(), req.URL.Hostname()
+	if from == to {
+		return nil
+	}
+	if host, ok := (forgeRepo{Host: from}).archiveHost(); ok && host == to {
+		return nil
+	}
+	return fmt.Errorf("refusing redirect from %s to %s", from, to)
+}
+
+func getHTTPResponseBody(ctx context.Context, url string) ([]byte, error) {
+	_, body, err := doHTTPRequestContext(ctx, url, nil)
+	return body, err
+}
+
+// checkSecureURL rejects plain http URLs unless -allow-insecure is set, so a
+// misconfigured mirror can't silently serve checksummed tarballs in the clear.
+func checkSecureURL(rawurl string) error {
+	if *allowInsecure || strings.HasPrefix(rawurl, "https://") {
+		return nil
+	}
+	return fmt.Errorf("%s: refusing non-https URL without -allow-insecure", rawurl)
+}
+
+// doHTTPRequest issues a GET for url with the extra header fields and returns
+// the response alongside its fully read and decoded body.
+func doHTTPRequest(url string, header http.Header) (*http.Response, []byte, error) {
+	return doHTTPRequestContext(requestCtx, url, header)
+}
+
+// doHTTPRequestContext is doHTTPRequest for a request, and its retries,
+// cancelled with ctx.
+func doHTTPRequestContext(ctx context.Context, url string, header http.Header) (*http.Response, []byte, error) {
+	if err := checkSecureURL(url); err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// Setting Accept-Encoding ourselves turns off the transport's transparent
+	// decompression, so only a gzip Content-Encoding is undone below; tarballs
+	// served as application/x-gzip are returned byte for byte.
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	for attempt := 0; ; attempt++ {
+		resp, body, err := doHTTPRequestOnce(req)
+		if attempt >= *retries || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, body, err
+		}
+		wait := retryDelay(attempt, resp)
+		warnf("%s: attempt %d failed, retrying in %v", url, attempt+1, wait.Round(time.Millisecond))
+		if !sleepContext(ctx, wait) {
+			return resp, body, ctx.Err()
+		}
+	}
+}
+
+func doHTTPRequestOnce(req *http.Request) (*http.Response, []byte, error) {
+	debugf("fetching %s", req.URL)
+	release := acquireHost(req.URL.String())
+	defer release()
+	limiter.Wait()
+	authorizeGitHub(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	logRateLimit(resp)
+
+	var r io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+// checkDistributionFile rejects a parsed distribution.yaml that holds nothing
+// useful, such as an error page that happened to parse as YAML.
+func checkDistributionFile(d DistroData) error {
+	if d.Type != "distribution" {
+		return fmt.Errorf("not a distribution file: type is %q", d.Type)
+	}
+	if len(d.Version) == 0 {
+		return errors.New("distribution file has no version")
+	}
+	if len(d.Repositories) == 0 {
+		return errors.New("distribution file lists no repositories")
+	}
+	return nil
+}
+
+// readDistributionFile returns the distribution file u, read from disk when
+// -distro-file is set and fetched otherwise.
+func readDistributionFile(u string) ([]byte, error) {
+	if len(*distroFilePath) > 0 {
+		return ioutil.ReadFile(u)
+	}
+	return getCachedHTTPResponseBody(u)
+}
+
+// getPackageList reads every distribution file of the distro, later files
+// overriding repositories of earlier ones as rosdistro does.
+func getPackageList() (DistroData, error) {
+	d := DistroData{Repositories: map[string]RepoData{}}
+	h := sha256.New()
+
+	sources := distro.DistributionURLs()
+	if len(*distroFilePath) > 0 {
+		sources = []string{distroFile(*distroFilePath)}
+	}
+	for _, u := range sources {
+		part := DistroData{}
+		body, err := readDistributionFile(u)
+		if err != nil {
+			return d, err
+		}
+		h.Write(body)
+
+		if err := yaml.Unmarshal(body, &part); err != nil {
+			return d, fmt.Errorf("%s: not a distribution file: %v", u, err)
+		}
+		if err := checkDistributionFile(part); err != nil {
+			return d, fmt.Errorf("%s: %v", u, err)
+		}
+
+		for name, repodata := range part.Repositories {
+			d.Repositories[name] = repodata
+		}
+		d.ReleasePlatforms = part.ReleasePlatforms
+		d.Version = part.Version
+		d.Type = part.Type
+	}
+	d.Checksum = fmt.Sprintf("%x", h.Sum(nil))
+
+	return d, nil
+}
+
+// providesList and replacesList render the provides= and replaces= values
+// for the former names of a package: each is provided at the version being
+// built and replaced in any version.
+func providesList(names []string) string {
+	provides := make([]string, len(names))
+	for i, name := range names {
+		provides[i] = name + "-${version}_${revision}"
+	}
+	return strings.Join(provides, " ")
+}
+
+func replacesList(names []string) string {
+	replaces := make([]string, len(names))
+	for i, name := range names {
+		replaces[i] = name + ">=0"
+	}
+	return strings.Join(replaces, " ")
+}
+
+var templateFuncs = template.FuncMap{
+	"fmt":            formatPackageName,
+	"fmtDesc":        formatShortDescription,
+	"fmtVersion":     formatVersionString,
+	"fmtRevision":    formatRevision,
+	"join":           strings.Join,
+	"fmtList":        formatDependencyList,
+	"fmtLicense":     formatLicense,
+	"esc":            escapeQuoted,
+	"distfileURLs":   distfileURLs,
+	"distfileSums":   distfileSums,
+	"provides":       providesList,
+	"replaces":       replacesList,
+	"baseline":       withBaseline,
+	"hostBaseline":   withHostBaseline,
+	"includeAuthors": authorsEnabled,
+	"prefix":         currentPrefix,
+}
+
+// parseGoTemplate parses the -template file. ParseFiles names the template
+// after the file's base name, which New must match for Execute to run it.
+func parseGoTemplate() (*template.Template, error) {
+	if _, err := os.Stat(*templatePath); err != nil {
+		return nil, fmt.Errorf("-template: %v", err)
+	}
+	return template.New(path.Base(*templatePath)).Funcs(templateFuncs).ParseFiles(*templatePath)
+}
+
+const metapackageTemplate = `# Template file for '{{.Name}}'
+pkgname={{.Name}}
+version=1.0
+revision=1
+build_style=meta
+depends="{{fmtList .Depends 9 "" true}}"
+short_desc="ROS - Metapackage depending on every generated package"
+maintainer="Young Jin Park <youngjinpark20@gmail.com>"
+license="BSD-3-Clause"
+homepage="http://www.ros.org"
+`
+
+// writeMetapackage emits a template named name that depends on every package
+// in pkgnames.
+func writeMetapackage(name string, pkgnames []string) error {
+	t, err := template.New("metapackage").Funcs(templateFuncs).Parse(metapackageTemplate)
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(pkgnames)
+	f, err := openVoidTemplateFile(name, "1.0")
+	if err != nil {
+		return err
+	}
+	err = t.Execute(f, struct {
+		Name    string
+		Depends []string
+	}{name, pkgnames})
+	if err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Close()
+}
+
+// templateFileName expands the -template-filename pattern for the package
+// name at version.
+func templateFileName(name, version string) string {
+	return strings.NewReplacer("{pkg}", name, "{version}", version).Replace(*templateFilename)
+}
+
+func openVoidTemplateFile(name, version string) (templateFile, error) {
+	if *dryRun {
+		return &stdoutEntry{name: name}, nil
+	}
+	if archive != nil {
+		return &tarEntry{name: archivePath(name, templateFileName(name, version))}, nil
+	}
+
+	// MkdirAll succeeds when another worker created the directory first.
+	p := path.Join(outputDir(), name)
+	if err := os.MkdirAll(p, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	f, err := createAtomic(path.Join(p, templateFileName(name, version)))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func getGithubRepoFromURL(url string) (string, error) {
+	re := regexp.MustCompile(`([^/]+/[^/]+?)(?:\.git)?/?$`)
+	if re.MatchString(url) {
+		return string(re.FindStringSubmatch(url)[1]), nil
+	} else {
+		return "", errors.New("Not a correct github URL.")
+	}
+}
+
+// packageXMLURL returns the raw URL of name's package.xml in the source
+// repository url at version. Discovery goes through the GitHub API and so
+// only applies to GitHub repositories.
+func packageXMLURL(name, version, url string) (string, error) {
+	repo, err := parseForgeRepo(url)
+	if err != nil {
+		return "", err
+	}
+
+	if p, ok := packagePathOverrides[name]; ok {
+		return repo.rawURL(version, p)
+	}
+	if *discoverPaths && repo.isGitHub() {
+		if p, ok := discoveredPackageXMLPath(repo.Project, version, name); ok {
+			return repo.rawURL(version, p)
+		}
+	}
+	return repo.rawURL(version, name+"/package.xml")
+}
+
+func getPackageXML(name, version, url string) (*SubPackage, error) {
+	var sp *SubPackage
+	rawurl, err := packageXMLURL(name, version, url)
+	if err != nil {
+		err = &FetchError{name, err}
+	} else {
+		sp, err = fetchPackageXML(name, rawurl)
+	}
+	return withCloneFallback(name, url, version, sp, err)
+}
+
+// releaseTag is the tag bloom gives the release of name at version in the
+// release repository.
+func releaseTag(name, version string) string {
+	return fmt.Sprintf("release/%s/%s/%s", distro.Name, name, version)
+}
+
+// releasePackageXMLURL locates package.xml in the bloom release repository,
+// where every package is tagged release/<distro>/<name>/<version> with its
+// manifest at the root.
+func releasePackageXMLURL(name, version, url string) (string, error) {
+	repo, err := parseForgeRepo(url)
+	if err != nil {
+		return "", err
+	}
+	return repo.rawURL(releaseTag(name, version), "package.xml")
+}
+
+func getReleasePackageXML(name, version, url string) (*SubPackage, error) {
+	var sp *SubPackage
+	rawurl, err := releasePackageXMLURL(name, version, url)
+	if err != nil {
+		err = &FetchError{name, err}
+	} else {
+		sp, err = fetchPackageXML(name, rawurl)
+	}
+	return withCloneFallback(name, url, releaseTag(name, version), sp, err)
+}
+
+// supportedFormats are the package.xml format versions the parser reads.
+// Format 1 manifests may omit the attribute.
+var supportedFormats = map[string]bool{"": true, "1": true, "2": true, "3": true}
+
+// checkFormat flags a package.xml of a format the parser doesn't know,
+// failing the package under -strict.
+func checkFormat(sp *SubPackage, diag *Diagnostics) error {
+	if supportedFormats[sp.Format] {
+		return nil
+	}
+	err := fmt.Errorf("package.xml format %s is not supported", sp.Format)
+	if *strict {
+		return &ParseError{sp.Name, err}
+	}
+	diag.Add(sp.Name, diagFormat, "%v, reading it as format 3", err)
+	return nil
+}
+
+func parsePackageXML(name string, body []byte) (*SubPackage, error) {
+	sp := &SubPackage{}
+	if err := xml.Unmarshal(body, sp); err != nil {
+		return nil, &ParseError{name, err}
+	}
+	sp.trimSpace()
+	sp.foldDependencies()
+	if sp.Name != name {
+		return nil, &ParseError{name, fmt.Errorf("package.xml is for %q", sp.Name)}
+	}
+	if err := sp.parseVersionConstraints(body); err != nil {
+		return nil, &ParseError{name, err}
+	}
+	return sp, nil
+}
+
+func fetchPackageXML(name, rawurl string) (*SubPackage, error) {
+	body, err := getValidatedHTTPResponseBody(rawurl, func(body []byte) error {
+		_, err := parsePackageXML(name, body)
+		return err
+	})
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return nil, err
+	} else if err != nil {
+		return nil, &FetchError{name, err}
+	}
+	return parsePackageXML(name, body)
+}
+
+// repositoriesDiverge reports whether the source and release URLs name
+// different projects, which happens when a repository moves upstream. Release
+// repositories normally live under another owner (e.g. ros-gbp) with a
+// "-release" suffix, so only the project names are compared.
+func repositoriesDiverge(repodata *RepoData) bool {
+	source, err := getGithubRepoFromURL(repodata.Source.URL)
+	if err != nil {
+		return false
+	}
+	release, err := getGithubRepoFromURL(repodata.Release.URL)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSuffix(path.Base(release), "-release") != path.Base(source)
+}
+
+// isNumericVersion reports whether s is a dotted numeric version such as
+// "1.2.3" or "v1.2.3".
+func isNumericVersion(s string) bool {
+	_, ok := parseVersion(s)
+	return ok
+}
+
+// splitReleaseVersion splits a bloom release version such as "1.2.3-1" into
+// the upstream version and the release increment.
+func splitReleaseVersion(version string) (string, string) {
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		return version[:i], version[i+1:]
+	}
+	return version, ""
+}
+
+// getTarballURL returns the tarball of the release of name at version from
+// the release repository url: the forge's archive of its release tag, or the
+// expanded -tarball-url pattern when one is given.
+func getTarballURL(name, version, url string) (string, error) {
+	if len(*tarballURLPattern) > 0 {
+		upstream, _ := splitReleaseVersion(version)
+		return strings.NewReplacer(
+			"{url}", strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git"),
+			"{distro}", distro.Name,
+			"{name}", name,
+			"{version}", version,
+			"{upstream_version}", upstream,
+		).Replace(*tarballURLPattern), nil
+	}
+	repo, err := parseForgeRepo(url)
+	if err != nil {
+		return "", err
+	}
+	return repo.archiveURL(releaseTag(name, version))
+}
+
+// githubArchive matches GitHub archive URLs, capturing the repository and ref.
+var githubArchive = regexp.MustCompile(`^https?://github\.com/[^/]+/([^/]+)/archive/(.+)\.tar\.gz$`)
+
+// githubVersionTag matches refs GitHub strips the leading "v" of when naming
+// the archive directory.
+var githubVersionTag = regexp.MustCompile(`^v[0-9]`)
+
+// gitlabArchive matches GitLab archive URLs, capturing the file name.
+var gitlabArchive = regexp.MustCompile(`^https?://[^/]+/.+/-/archive/.+/([^/]+)\.tar\.gz$`)
+
+// archiveWrksrc returns the directory a forge archive at url extracts to,
+// "<repo>-<ref>" with the slashes of the ref turned into dashes, or fallback
+// for tarballs hosted elsewhere. GitLab names the directory after the file
+// archiveURL asks for.
+func archiveWrksrc(url, fallback string) string {
+	if m := gitlabArchive.FindStringSubmatch(url); m != nil {
+		return m[1]
+	}
+	m := githubArchive.FindStringSubmatch(url)
+	if m == nil {
+		return fallback
+	}
+	ref := m[2]
+	if githubVersionTag.MatchString(ref) {
+		ref = ref[1:]
+	}
+	return m[1] + "-" + strings.ReplaceAll(ref, "/", "-")
+}
+
+// sourceTarballURL returns the forge archive of the source repository url at
+// version, for -allow-source builds of repositories without a release.
+func sourceTarballURL(url, version string) (string, error) {
+	repo, err := parseForgeRepo(url)
+	if err != nil {
+		return "", err
+	}
+	return repo.archiveURL(version)
+}
+
+// verifyGzip decompresses body completely to make sure it is an intact gzip
+// stream rather than a truncated download.
+func verifyGzip(body []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	_, err = io.Copy(ioutil.Discard, gz)
+	return err
+}
+
+// checkTarballResponse rejects responses that look like an error page rather
+// than a tarball, so their checksum never ends up in a template.
+func checkTarballResponse(url string, resp *http.Response, body []byte) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return fmt.Errorf("%s: got an HTML page instead of a tarball", url)
+	}
+	if len(body) < *minTarballBytes {
+		return fmt.Errorf("%s: tarball is only %d bytes", url, len(body))
+	}
+	return nil
+}
+
+// setChecksum fills in the checksum of the tarball from the first source
+// that has it, only downloading the tarball when none does. -verify-lockfile
+// always downloads, as only a recomputed checksum can reveal a tampered
+// tarball.
+func setChecksum(pkgname string, repodata *RepoData) error {
+	url := repodata.TarballURL
+	if *metadataOnly {
+		repodata.CheckSum, repodata.checksumSource = "", "placeholder, -metadata-only is set"
+		return nil
+	}
+	if checksum, ok := knownChecksums[url]; ok && !*verifyLockfile {
+		repodata.CheckSum, repodata.checksumSource = checksum, "-checksums-in"
+		return nil
+	}
+	if checksum, ok := existingChecksum(currentPrefix()+formatPackageName(pkgname), repodata.Release.Version, url); ok && !*force && !*verifyLockfile {
+		repodata.CheckSum, repodata.checksumSource = checksum, "the existing template"
+		return nil
+	}
+	if checksum, ok := cachedChecksum(url); ok && !*verifyLockfile {
+		repodata.CheckSum, repodata.checksumSource = checksum, "-cache-dir"
+		return nil
+	}
+	checksum, err := getTarballChecksum(url)
+	if err != nil {
+		return err
+	}
+	repodata.CheckSum, repodata.checksumSource = checksum, "downloaded tarball"
+	if *verifyLockfile {
+		return checkLockedChecksum(pkgname, repodata.Release.Version, checksum)
+	}
+	return nil
+}
+
+// getTarballChecksum downloads the tarball at url and stores its checksum in
+// the -cache-dir.
+func getTarballChecksum(url string) (string, error) {
+	resp, body, err := doHTTPRequest(url, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := checkTarballResponse(url, resp, body); err != nil {
+		return "", err
+	}
+
+	if *verifyTarball {
+		if err := verifyGzip(body); err != nil {
+			return "", fmt.Errorf("%s: corrupt tarball: %v", url, err)
+		}
+	}
+
+	checksum := fmt.Sprintf("%x", sha256.Sum256(body))
+	storeChecksum(url, checksum)
+	return checksum, nil
+}
+
+// checkTarballURL confirms that url is reachable without downloading it,
+// falling back to a single-byte ranged GET for servers that reject HEAD.
+func checkTarballURL(url string) error {
+	if err := checkSecureURL(url); err != nil {
+		return err
+	}
+	release := acquireHost(url)
+	defer release()
+	limiter.Wait()
+	req, err := http.NewRequestWithContext(requestCtx, "HEAD", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		req, err := http.NewRequestWithContext(requestCtx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		limiter.Wait()
+		resp, err = httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+
+	if resp.StatusCode >= 400 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func validateTarballURLs(d DistroData) bool {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failures := map[string]string{}
+
+	sem := make(chan struct{}, *jobs)
+	for pkgname, repodata := range d.Repositories {
+		if len(repodata.Release.URL) == 0 {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(pkgname string, repodata RepoData) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			cleanReleaseVersion(pkgname, &repodata)
+			url, err := getTarballURL(pkgname, repodata.Release.Version, repodata.Release.URL)
+			if err == nil {
+				if err = checkTarballURL(url); err != nil {
+					err = fmt.Errorf("%s: %v", url, err)
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				failures[pkgname] = err.Error()
+				mu.Unlock()
+			}
+		}(pkgname, repodata)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(failures))
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t%s\n", name, failures[name])
+	}
+	return len(failures) == 0
+}
+
+// uniquePackageNames drops repeated Release.Packages entries, which would
+// otherwise fetch the same package.xml twice and double its dependencies.
+func uniquePackageNames(pkgname string, names []string, diag *Diagnostics) []string {
+	seen := make(map[string]bool, len(names))
+	unique := names[:0:0]
+	for _, name := range names {
+		if seen[name] {
+			diag.Add(pkgname, diagDuplicatePackage, "duplicate package %s in release packages", name)
+			continue
+		}
+		seen[name] = true
+		unique = append(unique, name)
+	}
+	return unique
+}
+
+// dropDuplicatePackages leaves every package to the first repository, by
+// name, releasing it, so that no two templates build the same package. A
+// repository left with nothing to release is dropped entirely.
+func dropDuplicatePackages(d DistroData, diag *Diagnostics) {
+	repos := make([]string, 0, len(d.Repositories))
+	for name := range d.Repositories {
+		repos = append(repos, name)
+	}
+	sort.Strings(repos)
+
+	releasedBy := map[string]string{}
+	for _, reponame := range repos {
+		repodata := d.Repositories[reponame]
+		if len(repodata.Release.Packages) == 0 {
+			continue
+		}
+		var kept []string
+		for _, name := range repodata.Release.Packages {
+			if owner, ok := releasedBy[name]; ok && owner != reponame {
+				diag.Add(reponame, diagDuplicatePackage, "package %s is also released by %s, which keeps it", name, owner)
+				continue
+			}
+			releasedBy[name] = reponame
+			kept = append(kept, name)
+		}
+		if len(kept) == 0 {
+			delete(d.Repositories, reponame)
+			continue
+		}
+		repodata.Release.Packages = kept
+		d.Repositories[reponame] = repodata
+	}
+}
+
+// packageXMLFetcher returns how package.xml files of repodata's packages are
+// read: from the source repository, or from the release repository when the
+// two diverge.
+func packageXMLFetcher(repodata *RepoData) func(name string) (*SubPackage, error) {
+	if repositoriesDiverge(repodata) {
+		return func(name string) (*SubPackage, error) {
+			return getReleasePackageXML(name, repodata.Release.Version, repodata.Release.URL)
+		}
+	}
+	return func(name string) (*SubPackage, error) {
+		return getPackageXML(name, repodata.Source.Version, repodata.Source.URL)
+	}
+}
+
+func prepareAdditionalPackageData(pkgname string, repodata *RepoData, diag *Diagnostics) error {
+	if repositoriesDiverge(repodata) {
+		diag.Add(pkgname, diagDiverged, "source %s and release %s point at different repositories, reading package.xml from the release",
+			repodata.Source.URL, repodata.Release.URL)
+	}
+	fetch := packageXMLFetcher(repodata)
+
+	// Only repositories releasing several packages get sub-package stanzas;
+	// a source-only repository always renders as one flat package.
+	names := uniquePackageNames(pkgname, repodata.Release.Packages, diag)
+	if len(names) == 0 {
+		names = []string{pkgname}
+	}
+	repodata.SubPackages = nil
+
+	// A sub-package whose package.xml can't be read is left out rather than
+	// added as an empty entry that would pollute the dependency lists.
+	var lastErr error
+	for _, subpkgname := range names {
+		pkgxml, err := fetch(subpkgname)
+		if err == nil && len(pkgxml.Name) == 0 {
+			err = &ParseError{subpkgname, errors.New("package.xml has no name")}
+		}
+		if err != nil {
+			diag.Add(pkgname, diagSkippedPackage, "skipping sub-package: %v", err)
+			lastErr = err
+			continue
+		}
+		if err := checkFormat(pkgxml, diag); err != nil {
+			return err
+		}
+		if desc, ok := settings.ShortDescriptions[pkgxml.Name]; ok {
+			pkgxml.Description = desc
+		}
+		repodata.SubPackages = append(repodata.SubPackages, pkgxml)
+	}
+
+	if len(repodata.SubPackages) == 0 {
+		return lastErr
+	}
+	if !*splitSubpackages {
+		linkSiblings(repodata)
+	}
+	return nil
+}
+
+// linkSiblings turns run dependencies between the sub-packages of one
+// template into dependencies on the version being built.
+func linkSiblings(repodata *RepoData) {
+	siblings := map[string]bool{}
+	for _, sp := range repodata.SubPackages {
+		siblings[sp.Name] = true
+	}
+	for _, sp := range repodata.SubPackages {
+		sp.Siblings = siblings
+		for _, dep := range sp.RunDependencies {
+			if !siblings[dep] || dep == sp.Name {
+				continue
+			}
+			if sp.Versions == nil {
+				sp.Versions = map[string]string{}
+			}
+			sp.Versions[dep] = ">=${version}_${revision}"
+		}
+	}
+}
+
+// copyOverrideTemplate copies a hand-maintained template for pkgname into the
+// output tree, reporting false when no override exists.
+func copyOverrideTemplate(pkgname, version string) (bool, error) {
+	name := currentPrefix() + formatPackageName(pkgname)
+	body, err := ioutil.ReadFile(path.Join(*overridesDir, name+".template"))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if *checkMode {
+		return true, checkTemplateFile(pkgname, name, version, body)
+	}
+
+	f, err := openVoidTemplateFile(name, version)
+	if err != nil {
+		return true, err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Abort()
+		return true, err
+	}
+	return true, f.Close()
+}
+
+func hasOverrideTemplate(name string) bool {
+	if len(*overridesDir) == 0 {
+		return false
+	}
+	_, err := os.Stat(path.Join(*overridesDir, name+".template"))
+	return err == nil
+}
+
+// pruneOutput removes generated directories whose package has been dropped
+// from the distribution. Overridden packages are always left alone.
+func pruneOutput(d DistroData, dry bool) error {
+	keep := map[string]bool{*metapackage: true}
+	for pkgname, repodata := range d.Repositories {
+		keep[currentPrefix()+formatPackageName(pkgname)] = true
+		for _, subpkgname := range repodata.Release.Packages {
+			keep[currentPrefix()+formatPackageName(subpkgname)] = true
+		}
+	}
+
+	entries, err := ioutil.ReadDir(outputDir())
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || !strings.HasPrefix(name, currentPrefix()) || keep[name] || hasOverrideTemplate(name) {
+			continue
+		}
+
+		p := path.Join(outputDir(), name)
+		if dry {
+			fmt.Println("would prune " + p)
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		fmt.Println("pruned " + p)
+	}
+	return nil
+}
+
+func processPackage(pkgname string, repodata *RepoData, tmpl *template.Template, summary *Summary, state *State) {
+	var status, detail string
+	if *writeManifest {
+		defer func() { summary.addManifest(pkgname, repodata, status, detail) }()
+	}
+
+	if blacklist[pkgname] {
+		infof("blacklisted %s", pkgname)
+		summary.addSkipped(pkgname, skipBlacklisted, nil)
+		status, detail = manifestSkipped, skipBlacklisted
+		return
+	}
+
+	if len(*overridesDir) > 0 {
+		ok, err := copyOverrideTemplate(pkgname, repodata.Release.Version)
+		if err != nil {
+			err = &WriteError{pkgname, err}
+			summary.addFailed(pkgname, err)
+			status, detail = manifestFailed, err.Error()
+			return
+		}
+		if ok {
+			summary.addOverridden(pkgname, repodata)
+			summary.addGenerated(pkgname)
+			status = manifestOverridden
+			return
+		}
+	}
+
+	version := repodata.Release.Version
+	if state != nil && !*force && state.Done(pkgname, version) {
+		summary.addSkipped(pkgname, skipUpToDate, repodata)
+		status, detail = manifestSkipped, skipUpToDate
+		return
+	}
+	if *incremental && !*force && archive == nil && templateCurrent(pkgname, version) {
+		summary.addSkipped(pkgname, skipUpToDate, repodata)
+		status, detail = manifestSkipped, skipUpToDate
+		return
+	}
+
+	var written []string
+	var err error
+	if *fetchOnly {
+		err = prepareRepoData(pkgname, repodata, &summary.Diagnostics)
+	} else {
+		written, err = generateTemplate(pkgname, repodata, tmpl, &summary.Diagnostics)
+	}
+	var skip *SkipError
+	if errors.As(err, &skip) {
+		summary.addSkipped(pkgname, skip.Reason, nil)
+		status, detail = manifestSkipped, skip.Reason
+		return
+	} else if err != nil {
+		errorf("%v", err)
+		summary.addFailed(pkgname, err)
+		status, detail = manifestFailed, err.Error()
+		return
+	}
+	if *fetchOnly {
+		// Recording the checksum lets -checksums-out feed a later
+		// offline run through -checksums-in.
+		summary.addFetched(pkgname)
+		status = manifestFetched
+		if !*metadataOnly {
+			summary.addChecksum(repodata)
+		}
+		return
+	}
+	status = manifestGenerated
+	if len(written) == 0 {
+		return
+	}
+	summary.addGenerated(written...)
+	summary.addReferences(pkgname, repodata)
+	if *metadataOnly {
+		// Placeholder checksums must neither be exported nor let a
+		// resumed run skip the real generation.
+		return
+	}
+	summary.addChecksum(repodata)
+	if state != nil {
+		if old, ok := state.Version(pkgname); !ok {
+			summary.addChange(pkgname, "added")
+		} else if old != version {
+			summary.addChange(pkgname, old+" -> "+version)
+		}
+		if err := state.Record(pkgname, version); err != nil {
+			errorf("%s: recording state: %v", pkgname, err)
+		}
+	}
+}
+
+// checkDescriptions flags sub-packages whose description is shorter than
+// -min-desc, failing the package under -strict.
+func checkDescriptions(pkgname string, repodata *RepoData, diag *Diagnostics) error {
+	if *minDescription <= 0 {
+		return nil
+	}
+	for _, sp := range repodata.SubPackages {
+		desc := formatDescription(sp.Description, 0)
+		if len(desc) >= *minDescription {
+			continue
+		}
+		if *strict {
+			return &ParseError{sp.Name, fmt.Errorf("description %q is shorter than %d characters", desc, *minDescription)}
+		}
+		diag.Add(sp.Name, diagShortDescription, "description %q is shorter than %d characters", desc, *minDescription)
+	}
+	return nil
+}
+
+// prepareRepoData fills in everything the template needs about pkgname. A
+// *SkipError means there is nothing to generate.
+func prepareRepoData(pkgname string, repodata *RepoData, diag *Diagnostics) error {
+	var err error
+	repodata.Name = pkgname
+	repodata.Distro = distro
+	repodata.Vars = templateVars
+	repodata.Stamp = stamp
+	sourceOnly := len(repodata.Release.URL) == 0
+	if sourceOnly && (!*allowSource || len(repodata.Source.URL) == 0 || len(repodata.Source.Version) == 0) {
+		return &SkipError{pkgname, skipNoRelease}
+	}
+	cleanReleaseVersion(pkgname, repodata)
+	if len(repodata.Release.Version) == 0 {
+		if len(repodata.Source.Version) == 0 {
+			return &SkipError{pkgname, skipNoVersion}
+		}
+		// A branch such as melodic-devel is no package version.
+		if upstream, _ := splitReleaseVersion(repodata.Source.Version); !isNumericVersion(upstream) {
+			return &SkipError{pkgname, skipBranch}
+		}
+		diag.Add(pkgname, diagVersion, "no release version, using source version %s", repodata.Source.Version)
+		repodata.Release.Version = repodata.Source.Version
+	}
+	repodata.UpstreamVersion, repodata.ReleaseIncrement = splitReleaseVersion(repodata.Release.Version)
+	if sourceOnly {
+		repodata.TarballURL, err = sourceTarballURL(repodata.Source.URL, repodata.Source.Version)
+	} else {
+		repodata.TarballURL, err = getTarballURL(pkgname, repodata.Release.Version, repodata.Release.URL)
+	}
+	if err != nil {
+		return &FetchError{pkgname, err}
+	}
+	debugf("%s: tarball %s", pkgname, repodata.TarballURL)
+	repodata.Wrksrc = archiveWrksrc(repodata.TarballURL, pkgname+"-"+repodata.Release.Version)
+	if err := setChecksum(pkgname, repodata); err != nil {
+		return &ChecksumError{pkgname, err}
+	}
+
+	if err := prepareAdditionalPackageData(pkgname, repodata, diag); err != nil {
+		return err
+	}
+	if err := checkDescriptions(pkgname, repodata, diag); err != nil {
+		return err
+	}
+	if *resolveDepth > 0 {
+		for _, sp := range repodata.SubPackages {
+			sp.RunDependencies = resolveDependencies(sp.Name, sp.RunDependencies, *resolveDepth)
+		}
+	}
+	return nil
+}
+
+// generateTemplate renders the template for pkgname, returning the names of
+// the templates it wrote.
+func generateTemplate(pkgname string, repodata *RepoData, tmpl *template.Template, diag *Diagnostics) ([]string, error) {
+	if err := prepareRepoData(pkgname, repodata, diag); err != nil {
+		return nil, err
+	}
+
+	if *splitSubpackages && len(repodata.SubPackages) > 1 {
+		// Every sub-package becomes its own srcpkg built from the
+		// shared repository tarball.
+		var written []string
+		for _, sp := range repodata.SubPackages {
+			split := *repodata
+			split.Name = sp.Name
+			split.SubPackages = []*SubPackage{sp}
+			if err := writeTemplate(sp.Name, &split, tmpl, diag); err != nil {
+				return written, err
+			}
+			written = append(written, sp.Name)
+		}
+		return written, nil
+	}
+	if err := writeTemplate(pkgname, repodata, tmpl, diag); err != nil {
+		return nil, err
+	}
+	return []string{pkgname}, nil
+}
+
+// normalizeLineEndings converts CRLF and lone CR line endings to LF.
+func normalizeLineEndings(b []byte) []byte {
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\r"), []byte("\n"))
+}
+
+// pkgnameLine matches the pkgname= assignment of a rendered template.
+var pkgnameLine = regexp.MustCompile(`(?m)^pkgname=(.*)$`)
+
+// checkPkgname makes sure the template in body declares the pkgname of the
+// srcpkgs directory it is written to, as xbps-src requires. Both are named
+// after the repository, whatever packages it holds.
+func checkPkgname(name string, body []byte) error {
+	m := pkgnameLine.FindSubmatch(body)
+	if m == nil {
+		return errors.New("template sets no pkgname")
+	}
+	if got := strings.Trim(string(m[1]), `"'`); got != name {
+		return fmt.Errorf("template sets pkgname=%s but is written to srcpkgs/%s", got, name)
+	}
+	return nil
+}
+
+func writeTemplate(pkgname string, repodata *RepoData, tmpl *template.Template, diag *Diagnostics) error {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, repodata)
+	if err != nil {
+		return newRenderError(pkgname, err)
+	}
+
+	out := buf.Bytes()
+	if *forceLF {
+		out = normalizeLineEndings(out)
+	}
+
+	name := currentPrefix() + formatPackageName(pkgname)
+	if err := checkPkgname(name, out); err != nil {
+		if *strict || *checkMode {
+			return &RenderError{pkgname, "pkgname", err}
+		}
+		diag.Add(pkgname, diagPkgname, "%v", err)
+	}
+	if *preserveSections && archive == nil {
+		if out, err = preserveManualSections(name, repodata.Release.Version, out); err != nil {
+			return &WriteError{pkgname, err}
+		}
+	}
+	if *checkMode {
+		return checkTemplateFile(pkgname, name, repodata.Release.Version, out)
+	}
+
+	f, err := openVoidTemplateFile(name, repodata.Release.Version)
+	if err != nil {
+		return &WriteError{pkgname, err}
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Abort()
+		return &WriteError{pkgname, err}
+	}
+	if err := f.Close(); err != nil {
+		return &WriteError{pkgname, err}
+	}
+	return nil
+}
+
+// runDistro generates the current distro, reporting whether every package
+// succeeded. An error means the run couldn't be set up or its results
+// couldn't be saved.
+func runDistro(t *template.Template) (bool, error) {
+	var err error
+	var locked map[string]lockEntry
+	if len(*lockfile) > 0 {
+		locked, err = loadLockfile(distroFile(*lockfile))
+		if err != nil {
+			return false, err
+		}
+	}
+	lockedChecksums = nil
+	if *verifyLockfile {
+		lockedChecksums = locked
+	}
+
+	knownChecksums = nil
+	if len(*checksumsIn) > 0 {
+		knownChecksums, err = loadChecksums(distroFile(*checksumsIn))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if multiDistro {
+		infof("generating %s", distro.Name)
+		if archive == nil {
+			if err := os.MkdirAll(outputDir(), os.ModePerm); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if *headCheck {
+		return checkDistributionFreshness()
+	}
+
+	d, err := getPackageList()
+	if err != nil {
+		return false, err
+	}
+	stamp = newStamp(d.Checksum)
+
+	if *validateURLs {
+		return validateTarballURLs(d), nil
+	}
+
+	if *checkUpstream {
+		checkUpstreamVersions(d)
+		return true, nil
+	}
+
+	// -list-deps, -explain and -dump-data show dependencies after rosdep
+	// mapping too, so the rules are loaded before any of them runs.
+	if *useRosdep {
+		if err := loadRosdepRules(); err != nil {
+			return false, fmt.Errorf("rosdep: %v", err)
+		}
+	}
+
+	if len(*listDeps) > 0 {
+		return listDependencies(d, *listDeps), nil
+	}
+
+	if len(*explainPackage) > 0 {
+		return explain(d, *explainPackage, t), nil
+	}
+
+	if len(*dumpData) > 0 {
+		return dumpRepoData(d, *dumpData)
+	}
+
+	if *resolveDepth > 0 {
+		buildPackageIndex(d)
+	}
+
+	summary := &Summary{}
+	dropDuplicatePackages(d, &summary.Diagnostics)
+
+	var state *State
+	if len(*stateFile) > 0 {
+		state, err = loadState(distroFile(*stateFile))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	names := packageNames
+	if *onlyInvalid {
+		names, err = loadFailedPackages(distroFile(*summaryJSON))
+		if err != nil {
+			return false, fmt.Errorf("-only-invalid: %v", err)
+		}
+		if len(names) == 0 {
+			warnf("-only-invalid: the last run had no failures")
+			return true, nil
+		}
+	}
+	if *sampleSize > 0 && len(names) == 0 {
+		names = sampleRepositories(d, *sampleSize)
+	}
+	if len(*changedRosdep) > 0 {
+		names = repositoriesUsing(d, strings.Split(*changedRosdep, ","))
+		if len(names) == 0 {
+			warnf("-changed-rosdep: no repository depends on %s", *changedRosdep)
+			return true, nil
+		}
+	}
+
+	if len(names) == 0 {
+		repos := filteredRepositories(d)
+		var wg sync.WaitGroup
+		wg.Add(len(repos))
+		prog := newProgress(len(repos))
+		sem := make(chan struct{}, *jobs)
+		i := 0
+		for pkgname, repodata := range repos {
+			sem <- struct{}{}
+			delayStart(i)
+			i++
+			go func(pkgname string, repodata RepoData) {
+				processPackage(pkgname, &repodata, t, summary, state)
+				prog.finish(pkgname)
+				<-sem
+				wg.Done()
+			}(pkgname, repodata)
+		}
+		wg.Wait()
+
+		dangling := summary.DanglingReferences()
+		deps := make([]string, 0, len(dangling))
+		for dep := range dangling {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			summary.Diagnostics.Add(currentPrefix()+formatPackageName(dep), diagUnresolved,
+				"needed by %s but not generated", strings.Join(dangling[dep], " "))
+		}
+		if voidIndex != nil {
+			summary.checkInstallable()
+		}
+
+		// A filtered run generates only part of the distribution, which
+		// the metapackage must not be narrowed to.
+		if len(*metapackage) > 0 && !*fetchOnly && packageFilter == nil {
+			if err := writeMetapackage(*metapackage, summary.Generated); err != nil {
+				return false, err
+			}
+		}
+
+		if len(prune) > 0 && !*fetchOnly {
+			if err := pruneOutput(d, prune == "dry"); err != nil {
+				return false, err
+			}
+		}
+	} else {
+		infof("generating %s", strings.Join(names, ", "))
+		prog := newProgress(len(names))
+		for i, name := range names {
+			delayStart(i)
+			if repodata, ok := d.Repositories[name]; ok {
+				processPackage(name, &repodata, t, summary, state)
+				prog.finish(name)
+			} else {
+				errorf("unknown package %s", name)
+			}
+		}
+	}
+
+	if !*quiet {
+		summary.Print()
+		if *onlyInvalid {
+			summary.printRetried(names)
+		}
+	}
+	if *fetchOnly {
+		printCacheStats()
+	}
+
+	// Only a full run brings the whole output up to date with the
+	// distribution files.
+	if state != nil && len(names) == 0 && packageFilter == nil && !*metadataOnly && !*fetchOnly {
+		if err := state.RecordDistribution(d.Checksum); err != nil {
+			return false, err
+		}
+	}
+	if len(*lockfile) > 0 {
+		if err := summary.WriteLockfile(distroFile(*lockfile), locked); err != nil {
+			return false, err
+		}
+	}
+	if len(*checksumsOut) > 0 {
+		if err := summary.WriteChecksums(distroFile(*checksumsOut)); err != nil {
+			return false, err
+		}
+	}
+	if len(*summaryJSON) > 0 {
+		if err := summary.WriteJSON(distroFile(*summaryJSON)); err != nil {
+			return false, err
+		}
+	}
+	if len(*changelogFile) > 0 {
+		if err := summary.WriteChangelog(distroFile(*changelogFile)); err != nil {
+			return false, err
+		}
+	}
+	if *writeManifest {
+		if err := summary.WriteManifest(); err != nil {
+			return false, err
+		}
+	}
+
+	return !summary.Failed(), nil
+}
+
+func main() {
+	flag.Parse()
+
+	if len(*configFile) > 0 {
+		if err := loadSettings(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *fromStdin {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if name := strings.TrimSpace(scanner.Text()); len(name) > 0 {
+				packageNames = append(packageNames, name)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+		if len(packageNames) == 0 {
+			// An empty list must not turn into a full run.
+			log.Fatal("-from-stdin: no package names given")
+		}
+	}
+
+	if len(*blacklistFile) > 0 {
+		var err error
+		blacklist, err = loadNameList(*blacklistFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(*voidIndexFile) > 0 {
+		var err error
+		voidIndex, err = loadNameList(*voidIndexFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(*pathsFile) > 0 {
+		if err := loadPackagePathOverrides(*pathsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if conflicts := checkModeConflicts(); *checkMode && len(conflicts) > 0 {
+		log.Fatalf("-check writes nothing and can't be combined with %s", strings.Join(conflicts, " "))
+	}
+	if conflicts := checkModeConflicts(); *dryRun && len(conflicts) > 0 {
+		log.Fatalf("-dry-run writes nothing and can't be combined with %s", strings.Join(conflicts, " "))
+	}
+	if len(*filterPattern) > 0 {
+		if len(packageNames) > 0 || *sampleSize > 0 || len(*changedRosdep) > 0 || *onlyInvalid {
+			log.Fatal("-filter can't be combined with the other ways of selecting packages: -p, -sample, -changed-rosdep and -only-invalid")
+		}
+		re, err := regexp.Compile(*filterPattern)
+		if err != nil {
+			log.Fatalf("-filter: %v", err)
+		}
+		packageFilter = re
+	}
+
+	if *dryRun && *checkMode {
+		log.Fatal("-dry-run and -check can't be combined")
+	}
+
+	if *verifyLockfile && len(*lockfile) == 0 {
+		log.Fatal("-verify-lockfile needs -lockfile")
+	}
+
+	if *onlyInvalid && len(*summaryJSON) == 0 {
+		log.Fatal("-only-invalid and -retry-failed need -summary-json naming the previous run's summary")
+	}
+
+	if len(*changelogFile) > 0 && len(*stateFile) == 0 {
+		log.Fatal("-changelog needs -state to know the previous versions")
+	}
+
+	switch *depsStyle {
+	case "wrapped", "oneline", "multiline":
+	default:
+		log.Fatalf("-deps-style must be wrapped, oneline or multiline, not %q", *depsStyle)
+	}
+
+	if *jobs < 1 {
+		log.Fatal("-j must be at least 1")
+	}
+
+	if err := checkStampMode(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *maxDescription != 0 && *maxDescription < 10 {
+		log.Fatal("-max-desc must be 0 or at least 10")
+	}
+
+	var err error
+	cancelOnInterrupt()
+	client, err := newHTTPClient(*proxyURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	httpClient = client
+
+	if *requestRate > 0 {
+		limiter = newRateLimiter(*requestRate)
+	}
+
+	if len(distros) == 0 {
+		distros = stringList{"melodic"}
+	}
+	multiDistro = len(distros) > 1
+	distro = newDistro(distros[0])
+
+	if *printSchema {
+		if err := printRepoDataSchema(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	t, err := parseGoTemplate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *lintTemplate {
+		if !runTemplateLint(t) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(*dataIn) > 0 {
+		if err := renderDataFile(*dataIn, t); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(*outTar) > 0 {
+		if len(prune) > 0 {
+			log.Fatal("-prune can't be combined with -out-tar")
+		}
+		archive, err = createTarArchive(*outTar)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	stopProfiling, err := startProfiling()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for _, name := range distros {
+		distro = newDistro(name)
+		ok, err := runDistro(t)
+		if err != nil {
+			errorf("%s: %v", distro.Name, err)
+		}
+		if !ok {
+			failed = true
+		}
+	}
+
+	stopProfiling()
+
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			errorf("%v", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,1444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// stubFetcher answers requests from a map of URL to body, with a 404 for
+// every other URL.
+type stubFetcher map[string]string
+
+func (f stubFetcher) Do(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}, Request: req}
+	body, ok := f[req.URL.String()]
+	if !ok {
+		resp.StatusCode, resp.Status = http.StatusNotFound, "404 Not Found"
+	}
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	return resp, nil
+}
+
+// fetcherFunc answers every request with a function of it.
+type fetcherFunc func(req *http.Request) (*http.Response, error)
+
+func (f fetcherFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+// useFetcher serves every request of the test from f.
+func useFetcher(t *testing.T, f Fetcher) {
+	old := httpClient
+	httpClient = f
+	t.Cleanup(func() { httpClient = old })
+}
+
+// fixtureRepo is sampleRepoData releasing names, with the sub-packages parsed
+// from testdata/<dir>/<name>/package.xml. A single package names the
+// repository as well.
+func fixtureRepo(t *testing.T, dir string, names ...string) *RepoData {
+	t.Helper()
+	r := sampleRepoData()
+	r.Release.Packages = names
+	if len(names) == 1 {
+		r.Name = names[0]
+	}
+	r.SubPackages = nil
+	for _, name := range names {
+		body, err := ioutil.ReadFile(path.Join("testdata", dir, name, "package.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp, err := parsePackageXML(name, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SubPackages = append(r.SubPackages, sp)
+	}
+	linkSiblings(r)
+	return r
+}
+
+// subpackageRepo is the repository of the testdata/subpackages fixture,
+// whose two sub-packages both depend on roscpp.
+func subpackageRepo(t *testing.T) *RepoData {
+	t.Helper()
+	return fixtureRepo(t, "subpackages", "sample_core", "sample_tools")
+}
+
+// fixtureFetcher serves the package.xml files testdata/<dir> holds for the
+// released packages of r from its source repository.
+func fixtureFetcher(t *testing.T, r *RepoData, dir string) stubFetcher {
+	t.Helper()
+	f := stubFetcher{}
+	for _, name := range r.Release.Packages {
+		body, err := ioutil.ReadFile(path.Join("testdata", dir, name, "package.xml"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rawurl, err := packageXMLURL(name, r.Source.Version, r.Source.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f[rawurl] = string(body)
+	}
+	return f
+}
+
+// captureStdout returns what f prints to standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		done <- b
+	}()
+	defer func() {
+		os.Stdout = old
+	}()
+	f()
+	w.Close()
+	return string(<-done)
+}
+
+// rosdepFixture serves the rosdep rules of testdata/rosdep in place of the
+// rosdistro ones, along with the package.xml files of the fixture
+// distribution.
+func rosdepFixture(t *testing.T) stubFetcher {
+	t.Helper()
+	f := stubFetcher{}
+	for i, name := range []string{"base.yaml", "python.yaml"} {
+		body, err := ioutil.ReadFile(path.Join("testdata/rosdep", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f[rosdepURLs[i]] = string(body)
+	}
+	body, err := ioutil.ReadFile("testdata/rosdep/sample_rosdep/package.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawurl, err := packageXMLURL("sample_rosdep", "melodic-devel", "https://github.com/ros/sample_rosdep.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f[rawurl] = string(body)
+	return f
+}
+
+// renderTemplate renders the default template for r.
+func renderTemplate(t *testing.T, r *RepoData) string {
+	t.Helper()
+	tmpl, err := parseGoTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestEscapeQuoted(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, `plain`},
+		{`say "hi"`, `say \"hi\"`},
+		{"run `rm -rf`", "run \\`rm -rf\\`"},
+		{`costs $HOME`, `costs \$HOME`},
+		{`back\slash`, `back\\slash`},
+		{"all \"`$\\", "all \\\"\\`\\$\\\\"},
+	}
+	for _, tt := range tests {
+		if got := escapeQuoted(tt.in); got != tt.want {
+			t.Errorf("escapeQuoted(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestShortDescriptionEscaped(t *testing.T) {
+	r := sampleRepoData()
+	r.SubPackages[0].Description = "A \"quoted\" `ticked` $VAR package"
+	out := renderTemplate(t, r)
+	want := "short_desc=\"ROS - A \\\"quoted\\\" \\`ticked\\` \\$VAR package\"\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("rendered template lacks %q:\n%s", want, out)
+	}
+}
+
+func TestFormatDescription(t *testing.T) {
+	long := "Provides a very long description of the package that goes well past any sensible limit"
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"truncation off", long, 0, long},
+		{"short enough", "A short one.", 72, "A short one"},
+		{"default length", long, 72, "Provides a very long description of the package that goes..."},
+		{"custom length", long, 30, "Provides a very..."},
+		{"multi-byte runes", "Überprüft die Größe äußerst gründlich und ausführlich", 30, "Überprüft die Größe..."},
+		{"newlines collapsed", "Split\n   over\n\tlines.", 72, "Split over lines"},
+		{"two words at 20", "Robot localization", 20, "Robot..."},
+		{"two long words at 20", "Visualization toolkit", 20, "Visualizat..."},
+		{"two words fitting 20", "Tiny tool", 20, "Tiny tool"},
+	}
+	for _, tt := range tests {
+		got := formatDescription(tt.in, tt.max)
+		if got != tt.want {
+			t.Errorf("%s: formatDescription(%q, %d) = %q, want %q", tt.name, tt.in, tt.max, got, tt.want)
+		}
+		if tt.max > 0 && len([]rune("ROS - "+got)) >= tt.max {
+			t.Errorf("%s: %q is not below %d characters", tt.name, "ROS - "+got, tt.max)
+		}
+	}
+}
+
+func TestCleanReleaseVersion(t *testing.T) {
+	tests := []struct {
+		in, version, tarball, fmtVersion string
+		revision                         int
+	}{
+		{"1.14.3-1bionic", "1.14.3-1", "https://github.com/ros-gbp/ros_comm-release/archive/release/melodic/ros_comm/1.14.3-1.tar.gz", "1.14.3", 2},
+		{"1.14.3-0focal", "1.14.3-0", "https://github.com/ros-gbp/ros_comm-release/archive/release/melodic/ros_comm/1.14.3-0.tar.gz", "1.14.3", 1},
+		{"1.14.3-1", "1.14.3-1", "https://github.com/ros-gbp/ros_comm-release/archive/release/melodic/ros_comm/1.14.3-1.tar.gz", "1.14.3", 2},
+	}
+	for _, tt := range tests {
+		r := &RepoData{}
+		r.Release.Version = tt.in
+		cleanReleaseVersion("ros_comm", r)
+		if r.Release.Version != tt.version {
+			t.Errorf("cleaned %q to %q, want %q", tt.in, r.Release.Version, tt.version)
+		}
+		if got, err := getTarballURL("ros_comm", r.Release.Version, "https://github.com/ros-gbp/ros_comm-release.git"); err != nil || got != tt.tarball {
+			t.Errorf("%q: tarball %q (%v), want %q", tt.in, got, err, tt.tarball)
+		}
+		if got, err := formatVersionString(r.Release.Version); err != nil || got != tt.fmtVersion {
+			t.Errorf("%q: version %q (%v), want %q", tt.in, got, err, tt.fmtVersion)
+		}
+		if got := formatRevision(r.Release.Version); got != tt.revision {
+			t.Errorf("%q: revision %d, want %d", tt.in, got, tt.revision)
+		}
+	}
+}
+
+func TestDedupeAcrossSubpackages(t *testing.T) {
+	r := subpackageRepo(t)
+	if got, want := strings.Join(r.MakeDependencies(), " "), "std_msgs roscpp boost"; got != want {
+		t.Errorf("MakeDependencies() = %q, want %q", got, want)
+	}
+
+	out := renderTemplate(t, r)
+	makedepends := renderedField(out, "makedepends")
+	if n := strings.Count(makedepends, "ros-melodic-roscpp"); n != 1 {
+		t.Errorf("makedepends=%q lists roscpp %d times, want once", makedepends, n)
+	}
+	if !strings.Contains(makedepends, "boost") {
+		t.Errorf("makedepends=%q lacks the second sub-package's boost", makedepends)
+	}
+
+	// Without merging across sub-packages each one still lists a
+	// dependency once, and the rendered list never repeats one.
+	*dedupeSubpackages = false
+	defer func() { *dedupeSubpackages = true }()
+	r.SubPackages[1].BuildDepends = append(r.SubPackages[1].BuildDepends, "boost")
+	if got, want := strings.Join(r.MakeDependencies(), " "), "std_msgs roscpp boost roscpp"; got != want {
+		t.Errorf("raw MakeDependencies() = %q, want %q", got, want)
+	}
+	makedepends = renderedField(renderTemplate(t, r), "makedepends")
+	for _, dep := range []string{"ros-melodic-roscpp", "ros-melodic-boost"} {
+		if n := strings.Count(makedepends, dep); n != 1 {
+			t.Errorf("-dedupe-across-subpackages=false: makedepends=%q lists %s %d times, want once", makedepends, dep, n)
+		}
+	}
+}
+
+// renderedField returns the value of the first field= assignment in out.
+func renderedField(out, field string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, field+"=") {
+			return strings.Trim(strings.TrimPrefix(line, field+"="), `"`)
+		}
+	}
+	return ""
+}
+
+func TestGetPackageListAnchors(t *testing.T) {
+	old := *distroFilePath
+	defer func() { *distroFilePath = old }()
+	*distroFilePath = "testdata/anchors/distribution.yaml"
+
+	d, err := getPackageList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, fork := d.Repositories["roscpp_core"], d.Repositories["roscpp_core_fork"]
+
+	if got := strings.Join(fork.Release.Packages, " "); got != "cpp_common roscpp_serialization" {
+		t.Errorf("aliased packages = %q", got)
+	}
+	if got := fork.Release.Tags["release"]; got != "release/melodic/{package}/{version}" {
+		t.Errorf("aliased release tag = %q", got)
+	}
+	if fork.Source.URL != original.Source.URL || fork.Source.Type != "git" {
+		t.Errorf("merged source = %+v, want the url and type of %+v", fork.Source, original.Source)
+	}
+	if fork.Source.Version != "melodic-devel" {
+		t.Errorf("merged source version = %q, want the override melodic-devel", fork.Source.Version)
+	}
+
+	// Each alias must decode into its own map, so rewriting one
+	// repository's tags cannot leak into another's.
+	fork.Release.Tags["release"] = "changed"
+	if got := original.Release.Tags["release"]; got != "release/melodic/{package}/{version}" {
+		t.Errorf("anchored tags changed through an alias: %q", got)
+	}
+}
+
+func TestCheckPkgname(t *testing.T) {
+	tests := []struct {
+		name, body string
+		ok         bool
+	}{
+		{"match", "# Template file\npkgname=ros-melodic-foo\nversion=1\n", true},
+		{"quoted", "pkgname=\"ros-melodic-foo\"\n", true},
+		{"mismatch", "pkgname=ros-melodic-bar\n", false},
+		{"missing", "version=1\n", false},
+	}
+	for _, tt := range tests {
+		if err := checkPkgname("ros-melodic-foo", []byte(tt.body)); (err == nil) != tt.ok {
+			t.Errorf("%s: checkPkgname = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// TestPkgnameMultiPackage checks that a repository holding several
+// differently named packages renders the pkgname of its srcpkgs directory.
+func TestPkgnameMultiPackage(t *testing.T) {
+	r := subpackageRepo(t)
+	out := renderTemplate(t, r)
+	if err := checkPkgname(currentPrefix()+formatPackageName(r.Name), []byte(out)); err != nil {
+		t.Fatal(err)
+	}
+	if got := renderedField(out, "provides"); got != "ros-melodic-sample-core-${version}_${revision}" {
+		t.Errorf("provides = %q, want the first sub-package", got)
+	}
+}
+
+// TestSubpackageShortDescriptions checks that every sub-package stanza
+// describes its own package.xml rather than the repository's first.
+func TestSubpackageShortDescriptions(t *testing.T) {
+	out := renderTemplate(t, subpackageRepo(t))
+	i := strings.Index(out, "ros-melodic-sample-tools_package() {")
+	if i < 0 {
+		t.Fatalf("no sample_tools stanza in\n%s", out)
+	}
+	main, tools := out[:i], out[i:]
+
+	if got := renderedField(main, "short_desc"); got != "ROS - Core libraries of the sample repository" {
+		t.Errorf("main short_desc = %q", got)
+	}
+	want := "\tshort_desc=\"ROS - Command line tools for the sample repository\"\n"
+	if !strings.Contains(tools, want) {
+		t.Errorf("sample_tools stanza lacks %q:\n%s", want, tools)
+	}
+}
+
+func TestCheckDistributionFile(t *testing.T) {
+	tests := []struct {
+		name, body string
+		ok         bool
+	}{
+		{"valid", "type: distribution\nversion: 2\nrepositories:\n  roscpp: {}\n", true},
+		{"no type", "version: 2\nrepositories:\n  roscpp: {}\n", false},
+		{"no version", "type: distribution\nrepositories:\n  roscpp: {}\n", false},
+		{"no repositories", "type: distribution\nversion: 2\n", false},
+		{"plain text", "Service Unavailable\n", false},
+	}
+	for _, tt := range tests {
+		var d DistroData
+		if err := yaml.Unmarshal([]byte(tt.body), &d); err != nil {
+			if tt.ok {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err := checkDistributionFile(d); (err == nil) != tt.ok {
+			t.Errorf("%s: checkDistributionFile = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// TestGetPackageListErrorPage checks that an HTML error page served in place
+// of distribution.yaml is rejected instead of yielding an empty distro.
+func TestGetPackageListErrorPage(t *testing.T) {
+	page := "<!DOCTYPE html>\n<html><head><title>Unicorn! &middot; GitHub</title></head>\n<body>No server is currently available</body></html>\n"
+	useFetcher(t, stubFetcher{distro.ListURL(): page})
+
+	_, err := getPackageList()
+	if err == nil || !strings.Contains(err.Error(), "not a distribution file") {
+		t.Fatalf("getPackageList = %v, want a not a distribution file error", err)
+	}
+}
+
+func TestBuildtoolExportDependHostMakeDepends(t *testing.T) {
+	out := renderTemplate(t, fixtureRepo(t, "buildtool_export", "sample_msgs"))
+	if got := renderedField(out, "hostmakedepends"); !strings.Contains(got, "ros-melodic-genmsg") {
+		t.Errorf("hostmakedepends = %q, want ros-melodic-genmsg", got)
+	}
+	if got := renderedField(out, "makedepends"); strings.Contains(got, "genmsg") {
+		t.Errorf("makedepends = %q, want no genmsg", got)
+	}
+}
+
+func TestSubpackageDependsOnSourcepkg(t *testing.T) {
+	out := renderTemplate(t, subpackageRepo(t))
+	i := strings.Index(out, "ros-melodic-sample-tools_package() {")
+	if i < 0 {
+		t.Fatalf("no sample_tools stanza in\n%s", out)
+	}
+	want := "\tdepends=\"${sourcepkg}>=${version}_${revision} "
+	if !strings.Contains(out[i:], want) {
+		t.Errorf("sample_tools stanza lacks %q:\n%s", want, out[i:])
+	}
+}
+
+// TestDivergedRepositories checks that a repository whose source moved away
+// from its release is flagged and read from the release repository.
+func TestDivergedRepositories(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/diverged/moved_pkg/package.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RepoData{}
+	r.Source.URL = "https://github.com/new-owner/renamed_pkg.git"
+	r.Source.Version = "master"
+	r.Release.URL = "https://github.com/ros-gbp/moved_pkg-release.git"
+	r.Release.Version = "1.0.0-1"
+	r.Release.Packages = []string{"moved_pkg"}
+	if !repositoriesDiverge(r) {
+		t.Fatal("repositoriesDiverge = false for a moved source")
+	}
+	rawurl, err := releasePackageXMLURL("moved_pkg", r.Release.Version, r.Release.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFetcher(t, stubFetcher{rawurl: string(body)})
+
+	var diag Diagnostics
+	if err := prepareAdditionalPackageData("moved_pkg", r, &diag); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.SubPackages) != 1 || r.SubPackages[0].Name != "moved_pkg" {
+		t.Fatalf("sub-packages = %+v, want moved_pkg from the release", r.SubPackages)
+	}
+	entries := diag.Entries()
+	if len(entries) != 1 || entries[0].Category != diagDiverged {
+		t.Errorf("diagnostics = %+v, want one %s", entries, diagDiverged)
+	}
+}
+
+func TestRepositoriesDiverge(t *testing.T) {
+	tests := []struct {
+		source, release string
+		diverge         bool
+	}{
+		{"https://github.com/ros/roscpp_core.git", "https://github.com/ros-gbp/roscpp_core-release.git", false},
+		{"https://github.com/ros/roscpp_core.git", "https://github.com/ros/roscpp_core.git", false},
+		{"https://github.com/new-owner/renamed.git", "https://github.com/ros-gbp/original-release.git", true},
+	}
+	for _, tt := range tests {
+		r := &RepoData{}
+		r.Source.URL, r.Release.URL = tt.source, tt.release
+		if got := repositoriesDiverge(r); got != tt.diverge {
+			t.Errorf("repositoriesDiverge(%s, %s) = %v, want %v", tt.source, tt.release, got, tt.diverge)
+		}
+	}
+}
+
+// TestUnroutableAddress checks that a connection that never completes fails
+// the request promptly instead of panicking on the missing response.
+func TestUnroutableAddress(t *testing.T) {
+	oldTimeout, oldRetries := *httpTimeout, *retries
+	defer func() { *httpTimeout, *retries = oldTimeout, oldRetries }()
+	*httpTimeout, *retries = 200*time.Millisecond, 0
+	client, err := newHTTPClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFetcher(t, client)
+
+	start := time.Now()
+	if _, err := getHTTPResponseBody(context.Background(), "https://10.255.255.1/package.xml"); err == nil {
+		t.Fatal("request to an unroutable address succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v to fail", elapsed)
+	}
+}
+
+func TestFormatPackageName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"roscpp", "roscpp"},
+		{"tf2_ros", "tf2-ros"},
+		{"RobotHW", "robothw"},
+		{"Mixed_Case_Name", "mixed-case-name"},
+		{"ros.pkg", "ros-pkg"},
+		{"pkg.with_dots.v2", "pkg-with-dots-v2"},
+	}
+	for _, tt := range tests {
+		if got := formatPackageName(tt.in); got != tt.want {
+			t.Errorf("formatPackageName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestProxy checks that -proxy sends requests through the proxy rather than
+// to the host they name.
+func TestProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte("proxied body"))
+	}))
+	defer proxy.Close()
+
+	old := *allowInsecure
+	defer func() { *allowInsecure = old }()
+	*allowInsecure = true
+	client, err := newHTTPClient(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFetcher(t, client)
+
+	const url = "http://packages.example.invalid/package.xml"
+	body, err := getHTTPResponseBody(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "proxied body" {
+		t.Errorf("body = %q, want the proxy's", body)
+	}
+	if len(proxied) != 1 || proxied[0] != url {
+		t.Errorf("proxy saw %q, want one request for %s", proxied, url)
+	}
+}
+
+// TestMissingSubpackage checks that a sub-package whose package.xml is gone
+// is reported and left out while the rest of the repository still renders.
+func TestMissingSubpackage(t *testing.T) {
+	r := sampleRepoData()
+	r.Release.Packages = []string{"sample_core", "sample_missing"}
+	useFetcher(t, fixtureFetcher(t, r, "subpackages"))
+
+	var diag Diagnostics
+	if err := prepareAdditionalPackageData(r.Name, r, &diag); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.SubPackages) != 1 || r.SubPackages[0].Name != "sample_core" {
+		t.Fatalf("sub-packages = %+v, want only sample_core", r.SubPackages)
+	}
+	entries := diag.Entries()
+	if len(entries) != 1 || entries[0].Category != diagSkippedPackage || !strings.Contains(entries[0].Message, "sample_missing") {
+		t.Errorf("diagnostics = %+v, want sample_missing skipped", entries)
+	}
+
+	out := renderTemplate(t, r)
+	if strings.Contains(out, "sample-missing") {
+		t.Errorf("missing sub-package rendered:\n%s", out)
+	}
+	if got := renderedField(out, "short_desc"); got != "ROS - Core libraries of the sample repository" {
+		t.Errorf("short_desc = %q, want sample_core's", got)
+	}
+}
+
+// TestCustomPrefix checks that -prefix names the package and its ROS
+// dependencies while rosdep-resolved system packages keep their Void names.
+func TestCustomPrefix(t *testing.T) {
+	oldPrefix, oldRules := *packagePrefix, rosdepRules
+	defer func() { *packagePrefix, rosdepRules = oldPrefix, oldRules }()
+	*packagePrefix = "rosm-"
+	rosdepRules = map[string][]string{"boost": {"boost-devel"}}
+
+	out := renderTemplate(t, subpackageRepo(t))
+	if got := renderedField(out, "pkgname"); got != "rosm-sample-repo" {
+		t.Errorf("pkgname = %q, want rosm-sample-repo", got)
+	}
+	makedepends := renderedField(out, "makedepends")
+	for _, want := range []string{"rosm-roscpp", "rosm-std-msgs", "boost-devel"} {
+		if !strings.Contains(makedepends, want) {
+			t.Errorf("makedepends = %q, want %s", makedepends, want)
+		}
+	}
+	if strings.Contains(out, "rosm-boost") || strings.Contains(out, "ros-melodic-") {
+		t.Errorf("system dependency prefixed or default prefix left:\n%s", out)
+	}
+	if !strings.Contains(out, "\nrosm-sample-tools_package() {") {
+		t.Errorf("sub-package function not prefixed:\n%s", out)
+	}
+}
+
+// TestFormatDependencyListIndent checks the wrap column math of wrapped
+// lists for continuation lines indented with spaces and tabs.
+func TestFormatDependencyListIndent(t *testing.T) {
+	var deps []string
+	for i := 0; i < 20; i++ {
+		deps = append(deps, fmt.Sprintf("sample_dependency_%02d", i))
+	}
+	tests := []struct {
+		name   string
+		offset int
+		indent string
+	}{
+		{"two spaces", 17, "  "},
+		{"four spaces", 13, "    "},
+		{"tab", 53, "\t"},
+		{"no indent", 9, ""},
+	}
+	for _, tt := range tests {
+		lines := strings.Split(formatDependencyList(deps, tt.offset, tt.indent, true), "\n")
+		if len(lines) < 2 {
+			t.Errorf("%s: list was not wrapped", tt.name)
+			continue
+		}
+		for i, line := range lines {
+			width := tt.offset + len(line)
+			if i > 0 {
+				if !strings.HasPrefix(line, tt.indent+" ") {
+					t.Errorf("%s: line %d %q does not start with the indent", tt.name, i, line)
+				}
+				width = indentWidth(tt.indent) + len(strings.TrimPrefix(line, tt.indent))
+			}
+			if width > 100 {
+				t.Errorf("%s: line %d is %d columns wide", tt.name, i, width)
+			}
+			if i+1 < len(lines) {
+				next := strings.Fields(lines[i+1])[0]
+				if width+1+len(next) <= 100 {
+					t.Errorf("%s: line %d wrapped at %d columns although %s fits", tt.name, i, width, next)
+				}
+			}
+		}
+	}
+}
+
+func TestGetGithubRepoFromURL(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://github.com/ros/roscpp_core.git", "ros/roscpp_core"},
+		{"https://github.com/ros/roscpp_core", "ros/roscpp_core"},
+		{"https://github.com/owner/repo.name.git", "owner/repo.name"},
+		{"https://github.com/owner/repo.name", "owner/repo.name"},
+		{"https://github.com/ros/roscpp_core/", "ros/roscpp_core"},
+		{"https://github.com/owner/repo.name.git/", "owner/repo.name"},
+	}
+	for _, tt := range tests {
+		got, err := getGithubRepoFromURL(tt.url)
+		if err != nil || got != tt.want {
+			t.Errorf("getGithubRepoFromURL(%q) = %q, %v, want %q", tt.url, got, err, tt.want)
+		}
+	}
+}
+
+// TestNormalizeLineEndings checks that -normalize-line-endings leaves no CR
+// in a written template when the template file, package.xml and its
+// description all use CRLF.
+func TestNormalizeLineEndings(t *testing.T) {
+	oldOutput, oldLF, oldTemplate := outputPath, *forceLF, *templatePath
+	defer func() { outputPath, *forceLF, *templatePath = oldOutput, oldLF, oldTemplate }()
+	outputPath, *forceLF = t.TempDir(), true
+
+	src, err := ioutil.ReadFile(*templatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*templatePath = path.Join(t.TempDir(), "default.tmpl")
+	if err := ioutil.WriteFile(*templatePath, bytes.ReplaceAll(src, []byte("\n"), []byte("\r\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadFile("testdata/subpackages/sample_core/package.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlf := strings.ReplaceAll(string(body), "\n", "\r\n")
+	crlf = strings.Replace(crlf, "Core libraries of the sample repository.", "Core libraries\r\nof the sample\rrepository.", 1)
+	sp, err := parsePackageXML("sample_core", []byte(crlf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := sampleRepoData()
+	r.Name = "sample_core"
+	r.Release.Packages = []string{"sample_core"}
+	r.SubPackages = []*SubPackage{sp}
+
+	tmpl, err := parseGoTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTemplate("sample_core", r, tmpl, nil); err != nil {
+		t.Fatal(err)
+	}
+	name := currentPrefix() + formatPackageName("sample_core")
+	out, err := ioutil.ReadFile(path.Join(outputDir(), name, templateFileName(name, r.Release.Version)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := bytes.IndexByte(out, '\r'); i >= 0 {
+		t.Errorf("CR at byte %d of\n%q", i, out)
+	}
+}
+
+// countingFetcher is a stubFetcher recording how often each URL is asked for.
+type countingFetcher struct {
+	stubFetcher
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func (f *countingFetcher) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	if f.count == nil {
+		f.count = map[string]int{}
+	}
+	f.count[req.URL.String()]++
+	f.mu.Unlock()
+	return f.stubFetcher.Do(req)
+}
+
+// TestDuplicatePackages checks that a package released by two repositories
+// is left to the first by name and that a package listed twice by one
+// repository is fetched once.
+func TestDuplicatePackages(t *testing.T) {
+	old := *distroFilePath
+	defer func() { *distroFilePath = old }()
+	*distroFilePath = "testdata/duplicates/distribution.yaml"
+	d, err := getPackageList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diag Diagnostics
+	dropDuplicatePackages(d, &diag)
+	if got := strings.Join(d.Repositories["geometry"].Release.Packages, " "); got != "tf tf_conversions" {
+		t.Errorf("geometry releases %q, want it to keep tf", got)
+	}
+	if _, ok := d.Repositories["geometry_fork"]; ok {
+		t.Errorf("geometry_fork kept although all it released is tf")
+	}
+	entries := diag.Entries()
+	if len(entries) != 1 || entries[0].Package != "geometry_fork" || !strings.Contains(entries[0].Message, "released by geometry") {
+		t.Errorf("diagnostics = %+v, want geometry_fork warned about tf", entries)
+	}
+
+	r := d.Repositories["sample_repo"]
+	f := &countingFetcher{stubFetcher: fixtureFetcher(t, &r, "subpackages")}
+	useFetcher(t, f)
+	if err := prepareAdditionalPackageData("sample_repo", &r, &diag); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.SubPackages) != 1 {
+		t.Errorf("%d sub-packages, want sample_core once", len(r.SubPackages))
+	}
+	for url, n := range f.count {
+		if n != 1 {
+			t.Errorf("%s fetched %d times", url, n)
+		}
+	}
+}
+
+func TestGetTarballURLGitMidPath(t *testing.T) {
+	got, err := getTarballURL("bar", "1.0.0-1", "https://github.com/foo.github/bar.git")
+	want := "https://github.com/foo.github/bar/archive/release/melodic/bar/1.0.0-1.tar.gz"
+	if err != nil || got != want {
+		t.Errorf("getTarballURL = %q, %v, want %q", got, err, want)
+	}
+}
+
+// TestGetTarballURLOwnerAndDistro checks that an owner containing "git" and a
+// repository named after melodic survive, and that the release tag names the
+// current distro.
+func TestGetTarballURLOwnerAndDistro(t *testing.T) {
+	old := distro
+	defer func() { distro = old }()
+
+	tests := []struct {
+		distro, url, want string
+	}{
+		{"melodic", "https://github.com/mygit-robotics/melodic_tools-release.git",
+			"https://github.com/mygit-robotics/melodic_tools-release/archive/release/melodic/melodic_tools/0.2.0-1.tar.gz"},
+		{"noetic", "https://github.com/mygit-robotics/melodic_tools-release.git",
+			"https://github.com/mygit-robotics/melodic_tools-release/archive/release/noetic/melodic_tools/0.2.0-1.tar.gz"},
+		{"noetic", "https://github.com/gitgit/melodic_tools-release",
+			"https://github.com/gitgit/melodic_tools-release/archive/release/noetic/melodic_tools/0.2.0-1.tar.gz"},
+	}
+	for _, tt := range tests {
+		distro = newDistro(tt.distro)
+		if got, err := getTarballURL("melodic_tools", "0.2.0-1", tt.url); err != nil || got != tt.want {
+			t.Errorf("%s: getTarballURL(%q) = %q, %v, want %q", tt.distro, tt.url, got, err, tt.want)
+		}
+	}
+}
+
+// TestTarballErrorPage checks that error pages served in place of a tarball
+// are rejected before anything is hashed.
+func TestTarballErrorPage(t *testing.T) {
+	const url = "https://github.com/ros-gbp/sample_repo-release/archive/release/melodic/sample_repo/1.2.3-1.tar.gz"
+	tests := []struct {
+		name, contentType, body, want string
+	}{
+		{"tiny HTML body", "", "<html><body>Not Found</body></html>", "only"},
+		{"HTML content type", "text/html; charset=utf-8", strings.Repeat("<p>rate limited</p>", 100), "HTML page"},
+	}
+	for _, tt := range tests {
+		useFetcher(t, fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			header := http.Header{}
+			if len(tt.contentType) > 0 {
+				header.Set("Content-Type", tt.contentType)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: header, Request: req,
+				Body: ioutil.NopCloser(strings.NewReader(tt.body))}, nil
+		}))
+		if _, err := getTarballChecksum(url); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: getTarballChecksum = %v, want an error mentioning %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestHostToolClassification checks that a dependency configured as a host
+// tool lands in hostmakedepends and not in makedepends.
+func TestHostToolClassification(t *testing.T) {
+	old := settings
+	defer func() { settings = old }()
+	settings.HostTools = []string{"pkg-config"}
+
+	r := subpackageRepo(t)
+	r.SubPackages[1].BuildDepends = append(r.SubPackages[1].BuildDepends, "pkg-config")
+	out := renderTemplate(t, r)
+
+	if got := renderedField(out, "hostmakedepends"); !strings.Contains(got, "pkg-config") {
+		t.Errorf("hostmakedepends = %q, want pkg-config", got)
+	}
+	if got := renderedField(out, "makedepends"); strings.Contains(got, "pkg-config") {
+		t.Errorf("makedepends = %q, want no pkg-config", got)
+	}
+	if got := renderedField(out, "hostmakedepends"); !strings.Contains(got, "cmake") || !strings.Contains(got, "python3") {
+		t.Errorf("hostmakedepends = %q, want the cmake python3 baseline kept", got)
+	}
+}
+
+func TestCapitalizeDescription(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"utilities for ros.", "Utilities for ros."},
+		{"  a padded description", "A padded description"},
+		{"URDF parser for ROS", "URDF parser for ROS"},
+		{"ROS wrapper of the library", "ROS wrapper of the library"},
+		{"eProsima Fast RTPS", "eProsima Fast RTPS"},
+		{"überprüft alles", "Überprüft alles"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := capitalizeDescription(tt.in); got != tt.want {
+			t.Errorf("capitalizeDescription(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestEmptyReleaseVersion checks the source version fallback for releases
+// without a version: a tag is used, a branch or nothing skips the package.
+func TestEmptyReleaseVersion(t *testing.T) {
+	oldFile, oldMetadata := *distroFilePath, *metadataOnly
+	defer func() { *distroFilePath, *metadataOnly = oldFile, oldMetadata }()
+	*distroFilePath, *metadataOnly = "testdata/empty_version/distribution.yaml", true
+	d, err := getPackageList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, skip, version string
+	}{
+		{"tagged", "", "0.4.1"},
+		{"branch_only", skipBranch, ""},
+		{"unversioned", skipNoVersion, ""},
+	}
+	for _, tt := range tests {
+		r := d.Repositories[tt.name]
+		useFetcher(t, fixtureFetcher(t, &r, "empty_version"))
+		err := prepareRepoData(tt.name, &r, nil)
+		var skip *SkipError
+		switch {
+		case len(tt.skip) > 0:
+			if !errors.As(err, &skip) || skip.Reason != tt.skip {
+				t.Errorf("%s: prepareRepoData = %v, want skipped for %s", tt.name, err, tt.skip)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case r.Release.Version != tt.version:
+			t.Errorf("%s: version %q, want %q", tt.name, r.Release.Version, tt.version)
+		}
+	}
+}
+
+func TestVersionConstraints(t *testing.T) {
+	out := renderTemplate(t, fixtureRepo(t, "versioned", "sample_versioned"))
+	makedepends := renderedField(out, "makedepends")
+	for _, want := range []string{"ros-melodic-roscpp>=1.14.3", "ros-melodic-cpp-common>0.6.0<0.7.0"} {
+		if !strings.Contains(makedepends, want) {
+			t.Errorf("makedepends = %q, want %s", makedepends, want)
+		}
+	}
+	depends := renderedField(out, "depends")
+	if !strings.Contains(depends, "ros-melodic-roscpp>=1.14.3") {
+		t.Errorf("depends = %q, want ros-melodic-roscpp>=1.14.3", depends)
+	}
+	if !strings.Contains(depends, "ros-melodic-std-msgs") || strings.Contains(depends, "std-msgs>") {
+		t.Errorf("depends = %q, want std_msgs unpinned", depends)
+	}
+}
+
+// TestPaddedPackageXML checks that whitespace around element values never
+// reaches package names, dependencies or the build type.
+func TestPaddedPackageXML(t *testing.T) {
+	r := fixtureRepo(t, "whitespace", "sample_padded")
+	sp := r.SubPackages[0]
+	if sp.Name != "sample_padded" || sp.BuildType != "catkin" {
+		t.Errorf("name %q, build type %q", sp.Name, sp.BuildType)
+	}
+	if got := strings.Join(sp.BuildDepends, ","); got != "roscpp" {
+		t.Errorf("build depends = %q, want roscpp alone", got)
+	}
+	if got := strings.Join(sp.RunDependencies, ","); got != "std_msgs,roscpp" {
+		t.Errorf("run dependencies = %q", got)
+	}
+
+	out := renderTemplate(t, r)
+	if got := renderedField(out, "pkgname"); got != "ros-melodic-sample-padded" {
+		t.Errorf("pkgname = %q", got)
+	}
+	if got := renderedField(out, "short_desc"); got != "ROS - A package whose manifest pads every element" {
+		t.Errorf("short_desc = %q", got)
+	}
+	if got := renderedField(out, "depends"); got != "ros-melodic-roscpp ros-melodic-std-msgs" {
+		t.Errorf("depends = %q", got)
+	}
+}
+
+// TestInsecureDistfileRejected checks that a plain http tarball is refused
+// before any request unless -allow-insecure is set.
+func TestInsecureDistfileRejected(t *testing.T) {
+	const url = "http://github.com/ros-gbp/sample_repo-release/archive/release/melodic/sample_repo/1.2.3-1.tar.gz"
+	tarball := strings.Repeat("tarball ", 200)
+	f := &countingFetcher{stubFetcher: stubFetcher{url: tarball}}
+	useFetcher(t, f)
+
+	if _, err := getTarballChecksum(url); err == nil || !strings.Contains(err.Error(), "-allow-insecure") {
+		t.Errorf("getTarballChecksum = %v, want the http URL refused", err)
+	}
+	if err := checkTarballURL(url); err == nil {
+		t.Errorf("checkTarballURL accepted %s", url)
+	}
+	if len(f.count) != 0 {
+		t.Errorf("requests sent for a refused URL: %v", f.count)
+	}
+
+	old := *allowInsecure
+	defer func() { *allowInsecure = old }()
+	*allowInsecure = true
+	if _, err := getTarballChecksum(url); err != nil {
+		t.Errorf("with -allow-insecure: %v", err)
+	}
+}
+
+func TestDepsStyles(t *testing.T) {
+	old := *depsStyle
+	defer func() { *depsStyle = old }()
+	var deps []string
+	for i := 0; i < 20; i++ {
+		deps = append(deps, fmt.Sprintf("sample_dependency_%02d", i))
+	}
+
+	tests := []struct {
+		style     string
+		lines     int
+		continued bool
+	}{
+		{"wrapped", 10, false},
+		{"oneline", 1, false},
+		{"multiline", 20, true},
+	}
+	for _, tt := range tests {
+		*depsStyle = tt.style
+		out := formatDependencyList(deps, 9, "\t", true)
+		lines := strings.Split(out, "\n")
+		if len(lines) != tt.lines {
+			t.Errorf("%s: %d lines, want %d:\n%s", tt.style, len(lines), tt.lines, out)
+		}
+		if got := strings.Contains(out, "\\"); got != tt.continued {
+			t.Errorf("%s: line continuations %v, want %v:\n%s", tt.style, got, tt.continued, out)
+		}
+		if n := len(strings.Fields(strings.ReplaceAll(out, "\\", ""))); n != len(deps) {
+			t.Errorf("%s: %d dependencies rendered, want %d", tt.style, n, len(deps))
+		}
+	}
+}
+
+func TestUpstreamVersion(t *testing.T) {
+	tests := []struct {
+		version, upstream, increment string
+	}{
+		{"1.2.3-1", "1.2.3", "1"},
+		{"1.2.3-0", "1.2.3", "0"},
+		{"1.2.3", "1.2.3", ""},
+		{"2.0.0-rc1-2", "2.0.0-rc1", "2"},
+	}
+	for _, tt := range tests {
+		upstream, increment := splitReleaseVersion(tt.version)
+		if upstream != tt.upstream || increment != tt.increment {
+			t.Errorf("splitReleaseVersion(%q) = %q, %q, want %q, %q", tt.version, upstream, increment, tt.upstream, tt.increment)
+		}
+	}
+
+	old := *tarballURLPattern
+	defer func() { *tarballURLPattern = old }()
+	*tarballURLPattern = "{url}/archive/{upstream_version}.tar.gz"
+	got, err := getTarballURL("sample_repo", "1.2.3-1", "https://github.com/ros/sample_repo.git")
+	if want := "https://github.com/ros/sample_repo/archive/1.2.3.tar.gz"; err != nil || got != want {
+		t.Errorf("getTarballURL = %q, %v, want %q", got, err, want)
+	}
+}
+
+// sourceOnlyRepo returns the flat_tool repository of the testdata/source_only
+// distribution, with its package.xml served from the source repository.
+func sourceOnlyRepo(t *testing.T) (*RepoData, stubFetcher) {
+	t.Helper()
+	old := *distroFilePath
+	defer func() { *distroFilePath = old }()
+	*distroFilePath = "testdata/source_only/distribution.yaml"
+	d, err := getPackageList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := d.Repositories["flat_tool"]
+	body, err := ioutil.ReadFile("testdata/source_only/flat_tool/package.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawurl, err := packageXMLURL("flat_tool", r.Source.Version, r.Source.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &r, stubFetcher{rawurl: string(body)}
+}
+
+// TestSourceOnlyFlat checks that a repository without released packages
+// renders as a single flat package.
+func TestSourceOnlyFlat(t *testing.T) {
+	oldSource, oldMetadata := *allowSource, *metadataOnly
+	defer func() { *allowSource, *metadataOnly = oldSource, oldMetadata }()
+	*allowSource, *metadataOnly = true, true
+	r, f := sourceOnlyRepo(t)
+	useFetcher(t, f)
+
+	if err := prepareRepoData("flat_tool", r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.SubPackages) != 1 || r.SubPackages[0].Name != "flat_tool" {
+		t.Fatalf("sub-packages = %+v, want flat_tool alone", r.SubPackages)
+	}
+	out := renderTemplate(t, r)
+	if strings.Contains(out, "subpackages=") || strings.Contains(out, "_package()") {
+		t.Errorf("source-only repository rendered sub-packages:\n%s", out)
+	}
+	if got := renderedField(out, "pkgname"); got != "ros-melodic-flat-tool" {
+		t.Errorf("pkgname = %q", got)
+	}
+}
+
+// TestCheckRedirect serves every host from one TLS test server and checks
+// that GitHub's redirect to codeload is followed while one to any other host
+// is refused.
+func TestCheckRedirect(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Host == "github.com" && r.URL.Path == "/ros/sample/archive/1.0.tar.gz":
+			http.Redirect(w, r, "https://codeload.github.com/ros/sample/tar.gz/1.0", http.StatusFound)
+		case r.Host == "github.com":
+			http.Redirect(w, r, "https://downloads.example.com/sample.tar.gz", http.StatusFound)
+		default:
+			fmt.Fprintf(w, "served by %s", r.Host)
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: checkRedirect,
+	}
+
+	resp, err := client.Get("https://github.com/ros/sample/archive/1.0.tar.gz")
+	if err != nil {
+		t.Fatalf("redirect to codeload refused: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "served by codeload.github.com" {
+		t.Errorf("body = %q, want codeload's", body)
+	}
+
+	if _, err := client.Get("https://github.com/ros/sample/releases/download/sample.tar.gz"); err == nil || !strings.Contains(err.Error(), "refusing redirect") {
+		t.Errorf("redirect to another host = %v, want it refused", err)
+	}
+}
+
+// TestBlacklistedNeverWritten checks that a blacklisted repository is skipped
+// without fetching anything or writing its template.
+func TestBlacklistedNeverWritten(t *testing.T) {
+	oldOutput, oldBlacklist := outputPath, blacklist
+	defer func() { outputPath, blacklist = oldOutput, oldBlacklist }()
+	outputPath, blacklist = t.TempDir(), map[string]bool{"sample_repo": true}
+	f := &countingFetcher{stubFetcher: stubFetcher{}}
+	useFetcher(t, f)
+	tmpl, err := parseGoTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := &Summary{}
+	processPackage("sample_repo", sampleRepoData(), tmpl, summary, nil)
+	if got := summary.Skipped[skipBlacklisted]; len(got) != 1 || got[0] != "sample_repo" {
+		t.Errorf("skipped = %v, want sample_repo blacklisted", summary.Skipped)
+	}
+	entries, err := ioutil.ReadDir(outputDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 || len(f.count) != 0 {
+		t.Errorf("blacklisted package wrote %d entries and sent %d requests", len(entries), len(f.count))
+	}
+}
+
+// TestDistfilesOrder renders the distfiles= and checksum= lines of the
+// template for a multi-distfile fixture and checks every checksum sits at the
+// position of its URL.
+func TestDistfilesOrder(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/distfiles/multi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []struct {
+		URL      string `yaml:"url"`
+		CheckSum string `yaml:"checksum"`
+	}
+	if err := yaml.Unmarshal(body, &files); err != nil {
+		t.Fatal(err)
+	}
+	distfiles := make([]Distfile, len(files))
+	for i, f := range files {
+		distfiles[i] = Distfile{f.URL, f.CheckSum}
+	}
+
+	tmpl := template.Must(template.New("distfiles").Funcs(templateFuncs).Parse(
+		`distfiles="{{distfileURLs .}}"` + "\n" + `checksum="{{distfileSums .}}"` + "\n"))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, distfiles); err != nil {
+		t.Fatal(err)
+	}
+	fields := func(name string) []string {
+		m := regexp.MustCompile(`(?s)` + name + `="([^"]*)"`).FindStringSubmatch(buf.String())
+		if m == nil {
+			t.Fatalf("no %s= in\n%s", name, buf.String())
+		}
+		return strings.Fields(m[1])
+	}
+	urls, sums := fields("distfiles"), fields("checksum")
+	if len(urls) != len(files) || len(sums) != len(files) {
+		t.Fatalf("rendered %d distfiles and %d checksums for %d files", len(urls), len(sums), len(files))
+	}
+	for i, f := range files {
+		if urls[i] != f.URL || sums[i] != f.CheckSum {
+			t.Errorf("entry %d = %s %s, want %s %s", i, urls[i], sums[i], f.URL, f.CheckSum)
+		}
+	}
+}
+
+// TestSourceOnlyChecksum checks that -allow-source gates source-only
+// repositories, and that with it the template is built from the forge archive
+// of the source version with that archive's checksum.
+func TestSourceOnlyChecksum(t *testing.T) {
+	old := *allowSource
+	defer func() { *allowSource = old }()
+
+	*allowSource = false
+	r, f := sourceOnlyRepo(t)
+	useFetcher(t, f)
+	var skip *SkipError
+	if err := prepareRepoData("flat_tool", r, nil); !errors.As(err, &skip) || skip.Reason != skipNoRelease {
+		t.Errorf("without -allow-source prepareRepoData = %v, want a %q skip", err, skipNoRelease)
+	}
+
+	*allowSource = true
+	r, f = sourceOnlyRepo(t)
+	archive := strings.Repeat("flat_tool-0.3.0 archive\n", 64)
+	f["https://github.com/example/flat_tool/archive/0.3.0.tar.gz"] = archive
+	useFetcher(t, f)
+	if err := prepareRepoData("flat_tool", r, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := renderTemplate(t, r)
+	if got := renderedField(out, "version"); got != "0.3.0" {
+		t.Errorf("version = %q, want the source version 0.3.0", got)
+	}
+	if got := renderedField(out, "distfiles"); got != "https://github.com/example/flat_tool/archive/0.3.0.tar.gz" {
+		t.Errorf("distfiles = %s", got)
+	}
+	if got, want := renderedField(out, "checksum"), fmt.Sprintf("%x", sha256.Sum256([]byte(archive))); got != want {
+		t.Errorf("checksum = %s, want %s", got, want)
+	}
+}
+
+// TestSiblingDependency checks that sample_app's dependency on its sibling
+// sample_lib pins the sub-package built by the same template and isn't
+// emitted as an external package.
+func TestSiblingDependency(t *testing.T) {
+	out := renderTemplate(t, fixtureRepo(t, "siblings", "sample_lib", "sample_app"))
+	i := strings.Index(out, "ros-melodic-sample-app_package() {")
+	if i < 0 {
+		t.Fatalf("no sample_app stanza in\n%s", out)
+	}
+	if want := "ros-melodic-sample-lib>=${version}_${revision}"; !strings.Contains(out[i:], want) {
+		t.Errorf("sample_app stanza lacks %q:\n%s", want, out[i:])
+	}
+	for _, field := range []string{"makedepends", "depends"} {
+		if got := renderedField(out, field); strings.Contains(got, "ros-melodic-sample-lib") {
+			t.Errorf("%s = %q, want no external ros-melodic-sample-lib", field, got)
+		}
+	}
+}
+
+func TestArchiveWrksrc(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"https://github.com/ros-gbp/sample_repo-release/archive/release/melodic/sample_repo/1.2.3-1.tar.gz",
+			"sample_repo-release-release-melodic-sample_repo-1.2.3-1"},
+		{"https://github.com/ros-gbp/sample_repo-release/archive/release/melodic/sample_core/1.2.3-1.tar.gz",
+			"sample_repo-release-release-melodic-sample_core-1.2.3-1"},
+		{"https://github.com/example/flat_tool/archive/v0.3.0.tar.gz", "flat_tool-0.3.0"},
+		{"https://gitlab.com/sample-group/flat_tool/-/archive/v0.3.0/flat_tool-v0.3.0.tar.gz", "flat_tool-v0.3.0"},
+		{"https://downloads.example.com/sample_repo-1.2.3.tar.gz", "fallback"},
+	}
+	for _, tt := range tests {
+		if got := archiveWrksrc(tt.url, "fallback"); got != tt.want {
+			t.Errorf("archiveWrksrc(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// TestPackageWrksrc checks that a released package builds from the root of its
+// release archive and a source-only one from its directory of the source
+// archive.
+func TestPackageWrksrc(t *testing.T) {
+	out := renderTemplate(t, subpackageRepo(t))
+	if got := renderedField(out, "wrksrc"); got != "sample_repo-release-release-melodic-sample_repo-1.2.3-1" {
+		t.Errorf("release wrksrc = %q", got)
+	}
+	if want := "\twrksrc=\"sample_repo-release-release-melodic-sample_repo-1.2.3-1\"\n"; !strings.Contains(out, want) {
+		t.Errorf("sample_tools stanza lacks %q:\n%s", want, out)
+	}
+
+	oldSource, oldMetadata := *allowSource, *metadataOnly
+	defer func() { *allowSource, *metadataOnly = oldSource, oldMetadata }()
+	*allowSource, *metadataOnly = true, true
+	r, f := sourceOnlyRepo(t)
+	useFetcher(t, f)
+	if err := prepareRepoData("flat_tool", r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := renderedField(renderTemplate(t, r), "wrksrc"); got != "flat_tool-0.3.0/flat_tool" {
+		t.Errorf("source wrksrc = %q", got)
+	}
+}
+
+// TestShortDescriptionOverride checks that a short_desc rule replaces the
+// package.xml description of its package only.
+func TestShortDescriptionOverride(t *testing.T) {
+	old := settings
+	defer func() { settings = old }()
+	if err := loadSettings("testdata/rules/short_desc.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := sampleRepoData()
+	useFetcher(t, fixtureFetcher(t, r, "subpackages"))
+	if err := prepareAdditionalPackageData(r.Name, r, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := renderTemplate(t, r)
+	if got := renderedField(out, "short_desc"); got != "ROS - Sample core libraries for robots" {
+		t.Errorf("short_desc = %q, want the rule override", got)
+	}
+	if want := "\tshort_desc=\"ROS - Command line tools for the sample repository\""; !strings.Contains(out, want) {
+		t.Errorf("sample_tools stanza lacks its upstream %q:\n%s", want, out)
+	}
+}
+
+// TestBuildTypeStyles checks the build_style and catkin configure_args
+// emitted for the build_type package.xml exports.
+func TestBuildTypeStyles(t *testing.T) {
+	tests := []struct {
+		name, style string
+		catkin      bool
+	}{
+		{"catkin_pkg", "cmake", true},
+		{"cmake_pkg", "cmake", false},
+	}
+	for _, tt := range tests {
+		out := renderTemplate(t, fixtureRepo(t, "build_type", tt.name))
+		if got := renderedField(out, "build_style"); got != tt.style {
+			t.Errorf("%s: build_style = %q, want %q", tt.name, got, tt.style)
+		}
+		if got := strings.Contains(out, "-DCATKIN_BUILD_BINARY_PACKAGE=OFF"); got != tt.catkin {
+			t.Errorf("%s: catkin configure_args = %v, want %v:\n%s", tt.name, got, tt.catkin, out)
+		}
+	}
+}
+
+// TestFormatDependencyListSeparators renders a wrapped list in every
+// separator and lead style, opening the value and following other text.
+func TestFormatDependencyListSeparators(t *testing.T) {
+	oldSettings, oldStyle := settings, *depsStyle
+	defer func() { settings, *depsStyle = oldSettings, oldStyle }()
+	*depsStyle = "wrapped"
+
+	deps := []string{"sample_c", "sample_a", "sample_b"}
+	tests := []struct {
+		sep, lead string
+		first     bool
+		want      string
+	}{
+		{" ", " ", true, "ros-melodic-sample-a\n\t ros-melodic-sample-b ros-melodic-sample-c"},
+		{" ", " ", false, " ros-melodic-sample-a\n\t ros-melodic-sample-b ros-melodic-sample-c"},
+		{" ", "", true, "ros-melodic-sample-a\n\tros-melodic-sample-b ros-melodic-sample-c"},
+		{"  ", "  ", false, "  ros-melodic-sample-a\n\t  ros-melodic-sample-b  ros-melodic-sample-c"},
+		{" ", "\t", true, "ros-melodic-sample-a\n\t\tros-melodic-sample-b ros-melodic-sample-c"},
+	}
+	for _, tt := range tests {
+		settings.DepsSeparator, settings.DepsLead = tt.sep, tt.lead
+		if got := formatDependencyList(deps, 60, "\t", tt.first); got != tt.want {
+			t.Errorf("separator %q, lead %q, first %v: got %q, want %q", tt.sep, tt.lead, tt.first, got, tt.want)
+		}
+	}
+}
+
+// TestFormatDependencyListTabLead checks that a tab lead counts as the
+// columns it occupies when wrapping.
+func TestFormatDependencyListTabLead(t *testing.T) {
+	oldSettings, oldStyle := settings, *depsStyle
+	defer func() { settings, *depsStyle = oldSettings, oldStyle }()
+	*depsStyle = "wrapped"
+	settings.DepsSeparator, settings.DepsLead = " ", "\t"
+
+	var deps []string
+	for i := 0; i < 20; i++ {
+		deps = append(deps, fmt.Sprintf("sample_package_%02d", i))
+	}
+	lines := strings.Split(formatDependencyList(deps, 53, "\t", true), "\n")
+	for i, line := range lines[1:] {
+		if !strings.HasPrefix(line, "\t\t") {
+			t.Errorf("line %d %q does not start with indent and lead", i+1, line)
+		}
+		if width := indentWidth("\t\t") + len(strings.TrimPrefix(line, "\t\t")); width > 100 {
+			t.Errorf("line %d is %d columns wide", i+1, width)
+		}
+	}
+}
+
+// TestRenames checks the provides and replaces a renames rule adds to the
+// srcpkg and to the sub-package stanza, pinned to the version being built.
+func TestRenames(t *testing.T) {
+	old := settings
+	defer func() { settings = old }()
+	if err := loadSettings("testdata/rules/renames.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	r := subpackageRepo(t)
+
+	want := []string{"ros-melodic-old-core", "ros-melodic-sample-core"}
+	if got := r.Provides(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Provides = %v, want %v", got, want)
+	}
+	out := renderTemplate(t, r)
+	if got := renderedField(out, "provides"); got != "ros-melodic-old-core-${version}_${revision} ros-melodic-sample-core-${version}_${revision}" {
+		t.Errorf("provides = %q", got)
+	}
+	if got := renderedField(out, "replaces"); got != "ros-melodic-old-core>=0" {
+		t.Errorf("replaces = %q", got)
+	}
+	if want := "\tprovides=\"ros-melodic-old-tools-${version}_${revision}\"\n\treplaces=\"ros-melodic-old-tools>=0\"\n}"; !strings.Contains(out, want) {
+		t.Errorf("sample_tools stanza lacks %q:\n%s", want, out)
+	}
+}
+
+// TestGitLabRelease checks that a release repository on gitlab.com gets its
+// package.xml, tarball and wrksrc from GitLab rather than GitHub URLs.
+func TestGitLabRelease(t *testing.T) {
+	r := sampleRepoData()
+	r.Source.URL = "https://gitlab.com/sample-group/sample_repo.git"
+	r.Release.URL = "https://gitlab.com/sample-group/sample_repo-release.git"
+	f := fixtureFetcher(t, r, "subpackages")
+	const tarball = "https://gitlab.com/sample-group/sample_repo-release/-/archive/release/melodic/sample_repo/1.2.3-1/sample_repo-release-release-melodic-sample_repo-1.2.3-1.tar.gz"
+	archive := strings.Repeat("sample_repo 1.2.3-1 archive\n", 64)
+	f[tarball] = archive
+	useFetcher(t, f)
+
+	if err := prepareRepoData("sample_repo", r, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := renderTemplate(t, r)
+	if got := renderedField(out, "distfiles"); got != tarball {
+		t.Errorf("distfiles = %q, want %q", got, tarball)
+	}
+	if got, want := renderedField(out, "checksum"), fmt.Sprintf("%x", sha256.Sum256([]byte(archive))); got != want {
+		t.Errorf("checksum = %s, want %s", got, want)
+	}
+	if got := renderedField(out, "wrksrc"); got != "sample_repo-release-release-melodic-sample_repo-1.2.3-1" {
+		t.Errorf("wrksrc = %q", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path"
+	"sort"
+)
+
+var writeManifest = flag.Bool("manifest", false, "write manifest.json to the output directory, listing every processed repository with its version, tarball, checksum and status")
+
+// Manifest statuses.
+const (
+	manifestGenerated  = "generated"
+	manifestOverridden = "overridden"
+	manifestFetched    = "fetched"
+	manifestSkipped    = "skipped"
+	manifestFailed     = "error"
+)
+
+// ManifestEntry describes what became of one repository.
+type ManifestEntry struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	TarballURL  string `json:"tarball_url,omitempty"`
+	CheckSum    string `json:"checksum,omitempty"`
+	SubPackages int    `json:"subpackages"`
+	Status      string `json:"status"`
+
+	// Detail is the skip reason or error message.
+	Detail string `json:"detail,omitempty"`
+}
+
+func (s *Summary) addManifest(pkgname string, repodata *RepoData, status, detail string) {
+	s.mu.Lock()
+	s.Manifest = append(s.Manifest, ManifestEntry{
+		Name:        pkgname,
+		Version:     repodata.Release.Version,
+		TarballURL:  repodata.TarballURL,
+		CheckSum:    repodata.CheckSum,
+		SubPackages: len(repodata.SubPackages),
+		Status:      status,
+		Detail:      detail,
+	})
+	s.mu.Unlock()
+}
+
+// WriteManifest writes the manifest, sorted by repository name, to
+// manifest.json in the output directory.
+func (s *Summary) WriteManifest() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.Slice(s.Manifest, func(i, j int) bool {
+		return s.Manifest[i].Name < s.Manifest[j].Name
+	})
+	entries := s.Manifest
+	if entries == nil {
+		entries = []ManifestEntry{}
+	}
+	body, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir(), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(outputDir(), "manifest.json"), append(body, '\n'))
+}
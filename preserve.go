@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var preserveSections = flag.Bool("preserve-sections", false, "keep hand-written lines between # BEGIN MANUAL and # END MANUAL of existing templates when regenerating them")
+
+var (
+	manualBegin = []byte("# BEGIN MANUAL\n")
+	manualEnd   = []byte("# END MANUAL\n")
+)
+
+// manualSections returns what is between each pair of manual markers in body.
+func manualSections(body []byte) [][]byte {
+	var sections [][]byte
+	for {
+		i := bytes.Index(body, manualBegin)
+		if i < 0 {
+			return sections
+		}
+		body = body[i+len(manualBegin):]
+		j := bytes.Index(body, manualEnd)
+		if j < 0 {
+			return sections
+		}
+		sections = append(sections, body[:j])
+		body = body[j+len(manualEnd):]
+	}
+}
+
+// injectManualSections replaces the contents of the manual sections of out,
+// in order, with sections.
+func injectManualSections(out []byte, sections [][]byte) []byte {
+	var b bytes.Buffer
+	for _, section := range sections {
+		i := bytes.Index(out, manualBegin)
+		if i < 0 {
+			break
+		}
+		j := bytes.Index(out[i+len(manualBegin):], manualEnd)
+		if j < 0 {
+			break
+		}
+		b.Write(out[:i+len(manualBegin)])
+		b.Write(section)
+		out = out[i+len(manualBegin)+j:]
+	}
+	b.Write(out)
+	return b.Bytes()
+}
+
+// existingTemplate returns the path of the template name already has in the
+// output directory, whatever version its file name was rendered with. When
+// -template-filename left several behind the most recently written wins.
+func existingTemplate(name, version string) (string, error) {
+	dir := path.Join(outputDir(), name)
+	p := path.Join(dir, templateFileName(name, version))
+	if _, err := os.Stat(p); err == nil || !os.IsNotExist(err) {
+		return p, err
+	}
+	matches, err := filepath.Glob(path.Join(dir, templateFileName(name, "*")))
+	if err != nil || len(matches) == 0 {
+		return "", err
+	}
+	var newest time.Time
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return "", err
+		}
+		if fi.ModTime().After(newest) {
+			p, newest = m, fi.ModTime()
+		}
+	}
+	return p, nil
+}
+
+// preserveManualSections carries the manual sections of the template name
+// already has in the output directory over into out.
+func preserveManualSections(name, version string, out []byte) ([]byte, error) {
+	p, err := existingTemplate(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return out, nil
+	}
+	old, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return injectManualSections(out, manualSections(old)), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPreserveManualSectionsAcrossVersions(t *testing.T) {
+	oldOutput, oldFilename := outputPath, *templateFilename
+	defer func() { outputPath, *templateFilename = oldOutput, oldFilename }()
+
+	const manual = "# BEGIN MANUAL\nnocross=yes\n# END MANUAL\n"
+	regenerated := "pkgname=ros-melodic-foo\n# BEGIN MANUAL\n# END MANUAL\n"
+	tests := []struct {
+		name, filename, existing string
+	}{
+		{"fixed file name", "template", "template"},
+		{"same version", "template-{version}", "template-1.0.0-0"},
+		{"new version", "template-{version}", "template-0.9.0-0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath, *templateFilename = t.TempDir(), tt.filename
+			dir := path.Join(outputDir(), "ros-melodic-foo")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path.Join(dir, tt.existing), []byte("pkgname=ros-melodic-foo\n"+manual), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := preserveManualSections("ros-melodic-foo", "1.0.0-0", []byte(regenerated))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(out), manual) {
+				t.Errorf("manual section lost:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestPreserveManualSectionsNoTemplate(t *testing.T) {
+	old := outputPath
+	defer func() { outputPath = old }()
+	outputPath = t.TempDir()
+
+	regenerated := "pkgname=ros-melodic-foo\n# BEGIN MANUAL\n# END MANUAL\n"
+	out, err := preserveManualSections("ros-melodic-foo", "1.0.0-0", []byte(regenerated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != regenerated {
+		t.Errorf("out = %q, want it unchanged", out)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var (
+	pprofAddr  = flag.String("pprof", "", "serve net/http/pprof on this address during the run")
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of the generation run to this file")
+	memProfile = flag.String("memprofile", "", "write a heap profile to this file at the end of the run")
+)
+
+// startProfiling enables whichever profiling flags were given and returns a
+// function that finishes them. Nothing is started when they are all unset.
+func startProfiling() (func(), error) {
+	if len(*pprofAddr) > 0 {
+		go func() {
+			errorf("%v", http.ListenAndServe(*pprofAddr, nil))
+		}()
+	}
+
+	var cpu *os.File
+	if len(*cpuProfile) > 0 {
+		var err error
+		cpu, err = os.Create(*cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if len(*memProfile) > 0 {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				errorf("%v", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				errorf("%v", err)
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress counts finished packages and estimates the time remaining from a
+// moving average of the time between completions, which already accounts for
+// packages being processed concurrently.
+type progress struct {
+	mu    sync.Mutex
+	total int
+	done  int
+	last  time.Time
+	avg   time.Duration
+}
+
+// newProgress returns nil unless -v asks for progress, which finish ignores.
+func newProgress(total int) *progress {
+	if !*verbose {
+		return nil
+	}
+	return &progress{total: total, last: time.Now()}
+}
+
+func (p *progress) finish(pkgname string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	gap := now.Sub(p.last)
+	p.last = now
+	p.done++
+	if p.avg == 0 {
+		p.avg = gap
+	} else {
+		p.avg = (9*p.avg + gap) / 10
+	}
+	remaining := time.Duration(p.total-p.done) * p.avg
+	infof("[%d/%d] %s done, ~%s remaining", p.done, p.total, pkgname, formatETA(remaining))
+}
+
+func formatETA(d time.Duration) string {
+	if d >= time.Minute {
+		return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	}
+	return d.Round(time.Second).String()
+}
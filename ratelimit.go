@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	requestRate = flag.Float64("rate", 0, "maximum HTTP requests started per second across all workers, 0 for no limit")
+	perHost     = flag.Int("per-host", 0, "maximum concurrent HTTP requests to any one host, 0 for no limit")
+	startDelay  = flag.Duration("delay", 0, "pause between starting each package, on top of the -j limit; requests of running packages are still limited by -rate and -per-host")
+)
+
+// limiter throttles every outgoing request when -rate is set.
+var limiter *rateLimiter
+
+// rateLimiter hands out evenly spaced start times to concurrent callers, a
+// token bucket with a capacity of one.
+type rateLimiter struct {
+	mu       sync.Mutex
+	next     time.Time
+	interval time.Duration
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the caller may start its request. A nil limiter never
+// blocks.
+func (l *rateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// hostSlots holds a semaphore per hostname when -per-host is set.
+var hostSlots sync.Map
+
+// acquireHost blocks until a request to rawurl's host may run and returns the
+// func releasing the slot again.
+func acquireHost(rawurl string) func() {
+	if *perHost <= 0 {
+		return func() {}
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return func() {}
+	}
+	slots, _ := hostSlots.LoadOrStore(u.Hostname(), make(chan struct{}, *perHost))
+	sem := slots.(chan struct{})
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// delayStart sleeps for -delay before every package but the first, spacing
+// out dispatch rather than individual requests.
+func delayStart(i int) {
+	if i > 0 && *startDelay > 0 {
+		time.Sleep(*startDelay)
+	}
+}
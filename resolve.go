@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"sync"
+)
+
+var resolveDepth = flag.Int("resolve-depth", 0, "also list run dependencies of ROS dependencies this many levels deep in depends, 0 lists direct dependencies only")
+
+var (
+	// packageIndex maps every ROS package of the distro to the repository
+	// releasing it. It is built before workers start and only read after.
+	packageIndex map[string]*RepoData
+
+	// dependencyCache holds the run dependencies of packages already read
+	// while resolving, keyed by distro and package name.
+	dependencyCache sync.Map
+)
+
+func buildPackageIndex(d DistroData) {
+	packageIndex = map[string]*RepoData{}
+	for name, repodata := range d.Repositories {
+		if len(repodata.Release.URL) == 0 {
+			continue
+		}
+		r := repodata
+		r.Name = name
+		r.Release.Version, _ = splitDistroSuffix(r.Release.Version)
+		names := r.Release.Packages
+		if len(names) == 0 {
+			names = []string{name}
+		}
+		for _, pkg := range names {
+			packageIndex[pkg] = &r
+		}
+	}
+}
+
+// runDependencies reports the run dependencies of the ROS package name, and
+// false for anything that isn't released in the distro, e.g. system packages.
+func runDependencies(name string) ([]string, bool) {
+	repodata, ok := packageIndex[name]
+	if !ok {
+		return nil, false
+	}
+
+	key := distro.Name + "/" + name
+	if deps, ok := dependencyCache.Load(key); ok {
+		return deps.([]string), true
+	}
+	sp, err := packageXMLFetcher(repodata)(name)
+	if err != nil {
+		warnf("%s: resolving dependencies: %v", name, err)
+		return nil, false
+	}
+	dependencyCache.Store(key, sp.RunDependencies)
+	return sp.RunDependencies, true
+}
+
+// resolveDependencies extends the direct run dependencies of pkgname with the
+// run dependencies of its ROS dependencies up to depth levels deep. Every
+// package is visited once, so dependency cycles end the walk instead of
+// looping, and the result holds no duplicates.
+func resolveDependencies(pkgname string, direct []string, depth int) []string {
+	seen := map[string]bool{pkgname: true}
+	var resolved []string
+	for _, dep := range direct {
+		if !seen[dep] {
+			seen[dep] = true
+			resolved = append(resolved, dep)
+		}
+	}
+
+	level := resolved
+	for i := 0; i < depth && len(level) > 0; i++ {
+		var next []string
+		for _, name := range level {
+			deps, ok := runDependencies(name)
+			if !ok {
+				continue
+			}
+			for _, dep := range deps {
+				if !seen[dep] {
+					seen[dep] = true
+					next = append(next, dep)
+				}
+			}
+		}
+		resolved = append(resolved, next...)
+		level = next
+	}
+	return resolved
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var retries = flag.Int("retries", 3, "times a request is retried after a network error, 5xx or 429 response")
+
+// retryBase and retryMax bound the exponential backoff between attempts.
+const (
+	retryBase = 500 * time.Millisecond
+	retryMax  = 30 * time.Second
+)
+
+// retryable reports whether a request that ended with resp and err may
+// succeed when repeated. Other statuses, such as a 404 for a missing
+// package.xml, are returned right away.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var opErr *net.OpError
+		var dnsErr *net.DNSError
+		var netErr net.Error
+		return errors.As(err, &opErr) || errors.As(err, &dnsErr) ||
+			(errors.As(err, &netErr) && netErr.Timeout()) ||
+			errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryDelay is how long to wait before attempt+1 is retried: what a
+// Retry-After header asks for, or an exponential backoff with jitter.
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); len(after) > 0 {
+			if secs, err := strconv.Atoi(after); err == nil {
+				return capDelay(time.Duration(secs) * time.Second)
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				return capDelay(time.Until(t))
+			}
+		}
+	}
+	d := retryBase << uint(attempt)
+	return capDelay(d + time.Duration(rand.Int63n(int64(d)/2+1)))
+}
+
+func capDelay(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > retryMax {
+		return retryMax
+	}
+	return d
+}
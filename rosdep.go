@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// rosdepURLs are the rosdep rule files mapping system dependency keys to
+// distribution packages.
+var rosdepURLs = []string{
+	"https://raw.githubusercontent.com/ros/rosdistro/master/rosdep/base.yaml",
+	"https://raw.githubusercontent.com/ros/rosdistro/master/rosdep/python.yaml",
+}
+
+var (
+	useRosdep     = flag.Bool("rosdep", false, "map system dependencies to Void packages with the rosdep rules that have a void entry")
+	refreshRosdep = flag.Bool("refresh-rosdep", false, "fetch the rosdep rules again instead of revalidating the -cache-dir copy")
+)
+
+// rosdepRules maps rosdep keys to their Void packages. It is loaded for each
+// distro before workers start and only read after.
+var rosdepRules map[string][]string
+
+// voidRosdepPackages extracts the packages of a rosdep void entry, which is
+// either a list or a map holding a packages list.
+func voidRosdepPackages(v interface{}) []string {
+	var list []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		list = v
+	case map[interface{}]interface{}:
+		list, _ = v["packages"].([]interface{})
+	}
+	var pkgs []string
+	for _, p := range list {
+		if s, ok := p.(string); ok {
+			pkgs = append(pkgs, s)
+		}
+	}
+	return pkgs
+}
+
+func loadRosdepRules() error {
+	rules := map[string][]string{}
+	for _, u := range rosdepURLs {
+		if *refreshRosdep && len(*cacheDir) > 0 {
+			p := cachePath(u)
+			os.Remove(p + ".body")
+			os.Remove(p + ".json")
+		}
+		body, err := getCachedHTTPResponseBody(u)
+		if err != nil {
+			return err
+		}
+		var keys map[string]interface{}
+		if err := yaml.Unmarshal(body, &keys); err != nil {
+			return fmt.Errorf("%s: %v", u, err)
+		}
+		for key, v := range keys {
+			platforms, _ := v.(map[interface{}]interface{})
+			if pkgs := voidRosdepPackages(platforms["void"]); len(pkgs) > 0 {
+				rules[key] = pkgs
+			}
+		}
+	}
+	rosdepRules = rules
+	return nil
+}
+
+// rosdepPackages returns the Void packages rosdep maps dep to. Host tools and
+// ignored dependencies keep their own handling.
+func rosdepPackages(dep string) ([]string, bool) {
+	if isHostTool(dep) || ignoreList[dep] {
+		return nil, false
+	}
+	pkgs, ok := rosdepRules[rosdepKey(dep)]
+	return pkgs, ok
+}
+
+// rosdepKey is the rosdep key dep resolves through: its python variant for
+// the current distro when the rules have one, dep itself otherwise.
+func rosdepKey(dep string) string {
+	if key := pythonRosdepKey(dep); key != dep {
+		if _, ok := rosdepRules[key]; ok {
+			return key
+		}
+	}
+	return dep
+}
+
+// pythonRosdepKey swaps the python-* and python3-* variants of a rosdep key
+// for the python major version the current distro builds against, so e.g.
+// python-yaml resolves to the python3 package for a python 3 distro.
+func pythonRosdepKey(dep string) string {
+	major := strings.SplitN(distro.PythonVersion, ".", 2)[0]
+	switch {
+	case major == "3" && strings.HasPrefix(dep, "python-"):
+		return "python3-" + strings.TrimPrefix(dep, "python-")
+	case major == "2" && strings.HasPrefix(dep, "python3-"):
+		return "python-" + strings.TrimPrefix(dep, "python3-")
+	}
+	return dep
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPythonRosdepKey(t *testing.T) {
+	old := distro
+	defer func() { distro = old }()
+
+	tests := []struct {
+		distro, dep, want string
+	}{
+		{"melodic", "python-yaml", "python3-yaml"},
+		{"melodic", "python3-yaml", "python3-yaml"},
+		{"noetic", "python-yaml", "python3-yaml"},
+		{"noetic", "python3-yaml", "python3-yaml"},
+		{"melodic", "boost", "boost"},
+		{"noetic", "boost", "boost"},
+	}
+	for _, tt := range tests {
+		distro = newDistro(tt.distro)
+		if got := pythonRosdepKey(tt.dep); got != tt.want {
+			t.Errorf("%s: pythonRosdepKey(%q) = %q, want %q", tt.distro, tt.dep, got, tt.want)
+		}
+	}
+}
+
+// TestRosdepPackagesPerDistro checks that melodic and noetic, which both build
+// against python 3, resolve the python2 era python-yaml key to the python 3
+// Void package.
+func TestRosdepPackagesPerDistro(t *testing.T) {
+	oldDistro, oldRules := distro, rosdepRules
+	defer func() { distro, rosdepRules = oldDistro, oldRules }()
+	rosdepRules = map[string][]string{
+		"python-yaml":  {"python-yaml"},
+		"python3-yaml": {"python3-PyYAML"},
+	}
+
+	for _, name := range []string{"melodic", "noetic"} {
+		distro = newDistro(name)
+		pkgs, ok := rosdepPackages("python-yaml")
+		if got := strings.Join(pkgs, " "); !ok || got != "python3-PyYAML" {
+			t.Errorf("%s: rosdepPackages(python-yaml) = %q, %v, want python3-PyYAML", name, got, ok)
+		}
+	}
+}
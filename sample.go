@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	sampleSize = flag.Int("sample", 0, "generate only this many pseudo-randomly chosen repositories")
+	sampleSeed = flag.Int64("seed", 0, "seed for -sample, 0 for a new one each run; the seed used is printed")
+)
+
+// sampleRepositories picks n repositories of d. Names are sorted before
+// shuffling so the same seed always picks the same sample.
+func sampleRepositories(d DistroData, n int) []string {
+	names := make([]string, 0, len(d.Repositories))
+	for name := range d.Repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seed := *sampleSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+	if n < len(names) {
+		names = names[:n]
+	}
+	sort.Strings(names)
+	fmt.Printf("-sample %d -seed %d: %s\n", n, seed, strings.Join(names, " "))
+	return names
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+var stampMode = flag.String("stamp", "none", "header stamped on every template: none, ref for the generator and rosdistro used, or full to add the generation time")
+
+// rosdistroRef is the rosdistro branch the distribution files are read from.
+const rosdistroRef = "master"
+
+// Stamp records what a template was generated by and from. Time is empty
+// unless -stamp=full, keeping -stamp=ref output reproducible.
+type Stamp struct {
+	Generator string
+	Ref       string
+	Checksum  string
+	Time      string
+}
+
+// stamp is set for the current distro when -stamp isn't none.
+var stamp *Stamp
+
+func checkStampMode() error {
+	switch *stampMode {
+	case "none", "ref", "full":
+		return nil
+	}
+	return fmt.Errorf("-stamp must be none, ref or full, not %q", *stampMode)
+}
+
+// newStamp describes a run over the distribution files with checksum.
+func newStamp(checksum string) *Stamp {
+	if *stampMode == "none" {
+		return nil
+	}
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Main.Version) > 0 {
+		version = info.Main.Version
+	}
+	s := &Stamp{Generator: "void-ros-melodic " + version, Ref: rosdistroRef, Checksum: checksum}
+	if *stampMode == "full" {
+		s.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	return s
+}
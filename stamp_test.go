@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestStamp checks that the provenance header appears only when -stamp asks
+// for it, and the generation time only with -stamp=full.
+func TestStamp(t *testing.T) {
+	old := *stampMode
+	defer func() { *stampMode = old }()
+
+	tests := []struct {
+		mode         string
+		header, time bool
+	}{
+		{"none", false, false},
+		{"ref", true, false},
+		{"full", true, true},
+	}
+	for _, tt := range tests {
+		*stampMode = tt.mode
+		r := subpackageRepo(t)
+		r.Stamp = newStamp("0123abcd")
+		out := renderTemplate(t, r)
+		header := "# Generated by void-ros-melodic "
+		if got := strings.Contains(out, header); got != tt.header {
+			t.Errorf("-stamp=%s: header %q present = %v, want %v", tt.mode, header, got, tt.header)
+		}
+		if tt.header && !strings.Contains(out, "from rosdistro master, distribution sha256 0123abcd") {
+			t.Errorf("-stamp=%s: header lacks the rosdistro ref and checksum:\n%s", tt.mode, out)
+		}
+		if got := strings.Contains(out, "# Generated at "); got != tt.time {
+			t.Errorf("-stamp=%s: generation time present = %v, want %v", tt.mode, got, tt.time)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+)
+
+// atomicFile is written under a temporary name next to its destination and
+// only renamed into place by Close, so an interrupted run never leaves a
+// half-written file behind.
+type atomicFile struct {
+	*os.File
+	name string
+}
+
+func createAtomic(name string) (*atomicFile, error) {
+	f, err := ioutil.TempFile(path.Dir(name), "."+path.Base(name)+".")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, name: name}, nil
+}
+
+func (f *atomicFile) Close() error {
+	err := f.File.Chmod(0644)
+	if err == nil {
+		err = f.File.Close()
+	} else {
+		f.File.Close()
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+// Abort discards everything written so far and leaves the destination as it
+// was.
+func (f *atomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	f, err := createAtomic(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.Close()
+}
+
+// State records which release version of each package has been written, so an
+// interrupted batch run can pick up where it left off.
+type State struct {
+	mu       sync.Mutex
+	path     string
+	Packages map[string]string `json:"packages"`
+
+	// Distribution is the checksum of the distribution files the last
+	// run generated from.
+	Distribution string `json:"distribution,omitempty"`
+}
+
+func loadState(p string) (*State, error) {
+	st := &State{path: p, Packages: map[string]string{}}
+
+	body, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(body, st); err != nil {
+		return nil, err
+	}
+	if st.Packages == nil {
+		st.Packages = map[string]string{}
+	}
+	return st, nil
+}
+
+// Done reports whether pkgname was already written at version.
+func (st *State) Done(pkgname, version string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	v, ok := st.Packages[pkgname]
+	return ok && v == version
+}
+
+// Version returns the version pkgname was last written at.
+func (st *State) Version(pkgname string) (string, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	v, ok := st.Packages[pkgname]
+	return v, ok
+}
+
+// Record marks pkgname as written at version and saves the state file.
+func (st *State) Record(pkgname, version string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.Packages[pkgname] = version
+	return st.save()
+}
+
+// save writes the state file. The caller must hold st.mu.
+func (st *State) save() error {
+	body, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(st.path, body)
+}
+
+// RecordDistribution saves the checksum of the distribution files generated
+// from.
+func (st *State) RecordDistribution(checksum string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.Distribution = checksum
+	return st.save()
+}
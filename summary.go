@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Summary collects per-package outcomes from concurrent workers so they can be
+// reported once the run is over.
+type Summary struct {
+	mu         sync.Mutex
+	Generated  []string
+	Fetched    []string
+	Overridden []string
+	Skipped    map[string][]string
+	Failures   []Failure
+	Checksums  []ChecksumRecord
+	Changes    map[string]string
+
+	Diagnostics Diagnostics
+
+	// Provided holds every package name a written template provides, and
+	// References maps each ROS dependency to the packages needing it.
+	Provided   map[string]bool
+	References map[string][]string
+
+	// VoidDepends maps every Void package a written template depends on to
+	// the packages needing it, collected for -void-index.
+	VoidDepends map[string][]string
+
+	// Manifest records the outcome of every repository for -manifest.
+	Manifest []ManifestEntry
+}
+
+type Failure struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Error    string `json:"error"`
+}
+
+type ChecksumRecord struct {
+	Name       string
+	Version    string
+	TarballURL string
+	CheckSum   string
+}
+
+func (s *Summary) addGenerated(pkgnames ...string) {
+	s.mu.Lock()
+	s.Generated = append(s.Generated, pkgnames...)
+	s.mu.Unlock()
+}
+
+// provide marks pkgname and all its sub-packages as available. The caller
+// must hold s.mu.
+func (s *Summary) provide(pkgname string, repodata *RepoData) {
+	if s.Provided == nil {
+		s.Provided = map[string]bool{}
+		s.References = map[string][]string{}
+	}
+
+	s.Provided[pkgname] = true
+	for _, name := range repodata.Release.Packages {
+		s.Provided[name] = true
+	}
+	for _, sp := range repodata.SubPackages {
+		s.Provided[sp.Name] = true
+	}
+}
+
+// addReferences records the ROS packages provided and depended upon by a
+// written repository.
+func (s *Summary) addReferences(pkgname string, repodata *RepoData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.provide(pkgname, repodata)
+	for _, sp := range repodata.SubPackages {
+		deps := append(withBaseline(sp.Name, sp.HostMakeDependencies()), sp.MakeDependencies()...)
+		deps = append(deps, sp.RuntimeDependencies()...)
+		for _, dep := range deps {
+			if _, ok := rosdepPackages(dep); !ok && !ignoreList[dep] && !isHostTool(dep) {
+				s.References[dep] = append(s.References[dep], sp.Name)
+			}
+		}
+		if voidIndex != nil {
+			s.addVoidDepends(sp)
+		}
+	}
+}
+
+// DanglingReferences returns the dependencies no written template provides,
+// each with the sorted list of packages referencing it.
+func (s *Summary) DanglingReferences() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dangling := map[string][]string{}
+	for dep, users := range s.References {
+		if s.Provided[dep] {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, u := range users {
+			if !seen[u] {
+				seen[u] = true
+				dangling[dep] = append(dangling[dep], u)
+			}
+		}
+		sort.Strings(dangling[dep])
+	}
+	return dangling
+}
+
+func (s *Summary) addFetched(pkgname string) {
+	s.mu.Lock()
+	s.Fetched = append(s.Fetched, pkgname)
+	s.mu.Unlock()
+}
+
+func (s *Summary) addOverridden(pkgname string, repodata *RepoData) {
+	s.mu.Lock()
+	s.Overridden = append(s.Overridden, pkgname)
+	s.provide(pkgname, repodata)
+	s.mu.Unlock()
+}
+
+// addSkipped records why pkgname wasn't generated. Packages skipped with
+// their repodata, such as those already up to date, still provide their
+// packages since the earlier template stands.
+func (s *Summary) addSkipped(pkgname, reason string, repodata *RepoData) {
+	s.mu.Lock()
+	if s.Skipped == nil {
+		s.Skipped = map[string][]string{}
+	}
+	s.Skipped[reason] = append(s.Skipped[reason], pkgname)
+	if repodata != nil {
+		s.provide(pkgname, repodata)
+	}
+	s.mu.Unlock()
+}
+
+// skippedByReason returns the sorted reasons and sorts the packages of each.
+// The caller must hold s.mu.
+func (s *Summary) skippedByReason() (reasons []string, count int) {
+	for reason, names := range s.Skipped {
+		sort.Strings(names)
+		reasons = append(reasons, reason)
+		count += len(names)
+	}
+	sort.Strings(reasons)
+	return reasons, count
+}
+
+func (s *Summary) addFailed(pkgname string, err error) {
+	s.mu.Lock()
+	s.Failures = append(s.Failures, Failure{pkgname, errorCategory(err), err.Error()})
+	s.mu.Unlock()
+}
+
+func (s *Summary) addChecksum(repodata *RepoData) {
+	s.mu.Lock()
+	s.Checksums = append(s.Checksums, ChecksumRecord{repodata.Name, repodata.Release.Version, repodata.TarballURL, repodata.CheckSum})
+	s.mu.Unlock()
+}
+
+// WriteChecksums writes a name, tarball URL, checksum TSV of every generated
+// package to p.
+func (s *Summary) WriteChecksums(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.Slice(s.Checksums, func(i, j int) bool {
+		return s.Checksums[i].Name < s.Checksums[j].Name
+	})
+
+	var sb strings.Builder
+	for _, c := range s.Checksums {
+		fmt.Fprintf(&sb, "%s\t%s\t%s\n", c.Name, c.TarballURL, c.CheckSum)
+	}
+	return writeFileAtomic(p, []byte(sb.String()))
+}
+
+// addChange records how the version of pkgname changed since the last run.
+func (s *Summary) addChange(pkgname, change string) {
+	s.mu.Lock()
+	if s.Changes == nil {
+		s.Changes = map[string]string{}
+	}
+	s.Changes[currentPrefix()+formatPackageName(pkgname)] = change
+	s.mu.Unlock()
+}
+
+// WriteChangelog writes one "<pkg>: <old> -> <new>" or "<pkg>: added" line
+// per changed package to p, sorted by package name.
+func (s *Summary) WriteChangelog(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.Changes))
+	for name := range s.Changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s: %s\n", name, s.Changes[name])
+	}
+	return writeFileAtomic(p, []byte(sb.String()))
+}
+
+// loadChecksums reads a -checksums-out style TSV into a map from tarball URL
+// to checksum.
+func loadChecksums(p string) (map[string]string, error) {
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := map[string]string{}
+	for i, line := range strings.Split(string(body), "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected 3 tab-separated fields", p, i+1)
+		}
+		checksums[fields[1]] = fields[2]
+	}
+	return checksums, nil
+}
+
+func (s *Summary) Print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.Fetched) > 0 {
+		fmt.Printf("fetched (%d)\n", len(s.Fetched))
+	}
+	if len(s.Overridden) > 0 {
+		sort.Strings(s.Overridden)
+		fmt.Printf("overridden (%d): %s\n", len(s.Overridden), strings.Join(s.Overridden, " "))
+	}
+	if reasons, count := s.skippedByReason(); count > 0 {
+		fmt.Printf("skipped (%d):\n", count)
+		for _, reason := range reasons {
+			names := s.Skipped[reason]
+			fmt.Printf("  %s (%d): %s\n", reason, len(names), strings.Join(names, " "))
+		}
+	}
+	if len(s.Failures) > 0 {
+		s.sortFailures()
+		fmt.Printf("failed (%d):\n", len(s.Failures))
+		byCategory := map[string][]Failure{}
+		var categories []string
+		for _, f := range s.Failures {
+			if _, ok := byCategory[f.Category]; !ok {
+				categories = append(categories, f.Category)
+			}
+			byCategory[f.Category] = append(byCategory[f.Category], f)
+		}
+		sort.Strings(categories)
+		for _, c := range categories {
+			fmt.Printf("  %s (%d):\n", c, len(byCategory[c]))
+			for _, f := range byCategory[c] {
+				fmt.Printf("\t%s\n", f.Error)
+			}
+		}
+	}
+	if diags := s.Diagnostics.Entries(); len(diags) > 0 {
+		fmt.Printf("warnings (%d):\n", len(diags))
+		category := ""
+		for _, d := range diags {
+			if d.Category != category {
+				category = d.Category
+				fmt.Printf("  %s:\n", category)
+			}
+			fmt.Printf("\t%s: %s\n", d.Package, d.Message)
+		}
+	}
+}
+
+// printRetried reports how many of the retried packages now succeeded.
+func (s *Summary) printRetried(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed := map[string]bool{}
+	for _, f := range s.Failures {
+		failed[f.Name] = true
+	}
+	still := 0
+	for _, name := range names {
+		if failed[name] {
+			still++
+		}
+	}
+	fmt.Printf("retried (%d): %d recovered, %d still failing\n", len(names), len(names)-still, still)
+}
+
+// sortFailures orders the failures by package name. The caller must hold s.mu.
+func (s *Summary) sortFailures() {
+	sort.Slice(s.Failures, func(i, j int) bool {
+		return s.Failures[i].Name < s.Failures[j].Name
+	})
+}
+
+// Failed reports whether any package failed.
+func (s *Summary) Failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Failures) > 0
+}
+
+// summaryReport is the run-level report written by -summary-json.
+type summaryReport struct {
+	Generated   int                 `json:"generated"`
+	Overridden  int                 `json:"overridden"`
+	Skipped     int                 `json:"skipped"`
+	SkipReasons map[string][]string `json:"skip_reasons"`
+	Failed      int                 `json:"failed"`
+	Failures    []Failure           `json:"failures"`
+
+	Diagnostics []Diagnostic `json:"diagnostics"`
+
+	// UnresolvedDependencies counts ROS dependencies no template provides,
+	// and UnresolvedReferences the packages depending on them.
+	UnresolvedDependencies int `json:"unresolved_dependencies"`
+	UnresolvedReferences   int `json:"unresolved_references"`
+}
+
+// WriteJSON writes the aggregate results of the run to p.
+func (s *Summary) WriteJSON(p string) error {
+	dangling := s.DanglingReferences()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sortFailures()
+	_, skipped := s.skippedByReason()
+	report := summaryReport{
+		Generated:              len(s.Generated),
+		Overridden:             len(s.Overridden),
+		Skipped:                skipped,
+		SkipReasons:            s.Skipped,
+		Failed:                 len(s.Failures),
+		Failures:               s.Failures,
+		UnresolvedDependencies: len(dangling),
+	}
+	if report.Failures == nil {
+		report.Failures = []Failure{}
+	}
+	if report.SkipReasons == nil {
+		report.SkipReasons = map[string][]string{}
+	}
+	report.Diagnostics = s.Diagnostics.Entries()
+	for _, users := range dangling {
+		report.UnresolvedReferences += len(users)
+	}
+
+	body, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, append(body, '\n'))
+}
+
+// loadFailedPackages returns the packages that failed in the -summary-json
+// report at p.
+func loadFailedPackages(p string) ([]string, error) {
+	body, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var report summaryReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("%s: %v", p, err)
+	}
+	var names []string
+	for _, f := range report.Failures {
+		names = append(names, f.Name)
+	}
+	return names, nil
+}
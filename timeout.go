@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"time"
+)
+
+var httpTimeout = flag.Duration("timeout", 2*time.Minute, "give up on a request, including reading its body, after this long; 0 for no limit")
+
+// requestCtx is cancelled on the first interrupt, failing every in-flight
+// and later request so the run winds down and still prints its summary.
+var requestCtx = context.Background()
+
+// cancelOnInterrupt sets up requestCtx. A second interrupt isn't caught and
+// kills the process as usual.
+func cancelOnInterrupt() {
+	ctx, cancel := context.WithCancel(context.Background())
+	requestCtx = ctx
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		warnf("interrupted, cancelling requests")
+		cancel()
+	}()
+}
+
+// sleepContext sleeps for d unless ctx is done first, reporting whether the
+// whole delay passed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
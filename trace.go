@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+var traceHTTP = flag.Bool("trace-http", false, "log the method, URL, status, duration and size of every HTTP request")
+
+// tracingTransport logs request metadata for -trace-http. Headers are never
+// logged, so Authorization and similar credentials stay out of the output.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	u := traceURL(req.URL)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("http: %s %s: %v (%v)", req.Method, u, err, time.Since(start))
+		return nil, err
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, method: req.Method, url: u, status: resp.Status, start: start}
+	return resp, nil
+}
+
+// tracedBody counts the bytes read from a response and logs the request once
+// the body is closed.
+type tracedBody struct {
+	io.ReadCloser
+	method, url, status string
+	start               time.Time
+	size                int64
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	log.Printf("http: %s %s: %s, %d bytes (%v)", b.method, b.url, b.status, b.size, time.Since(b.start))
+	return b.ReadCloser.Close()
+}
+
+// traceURL strips the user info and the values of credential-like query
+// parameters from u, as either may carry a token.
+func traceURL(u *neturl.URL) string {
+	c := *u
+	c.User = nil
+	q := c.Query()
+	for key := range q {
+		k := strings.ToLower(key)
+		if strings.Contains(k, "token") || strings.Contains(k, "key") || strings.Contains(k, "secret") || strings.Contains(k, "signature") {
+			q.Set(key, "REDACTED")
+		}
+	}
+	if len(c.RawQuery) > 0 {
+		c.RawQuery = q.Encode()
+	}
+	return c.String()
+}
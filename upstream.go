@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+var checkUpstream = flag.Bool("check-upstream-version", false, "report packages whose upstream GitHub tags are newer than the released version")
+
+type githubTag struct {
+	Name string `json:"name"`
+}
+
+// parseVersion splits a dotted numeric version such as "v1.14.3" into its
+// components, reporting false for anything that isn't purely numeric.
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(s, "v")
+	var parts []int
+	for _, f := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func githubAPIHeader() http.Header {
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github.v3+json")
+	return header
+}
+
+// latestUpstreamTag returns the highest numeric tag of the GitHub repository
+// owner/name.
+func latestUpstreamTag(repo string) (string, error) {
+	url := fmt.Sprintf("%s/repos/%s/tags?per_page=100", githubAPIURL, repo)
+	resp, body, err := doHTTPRequest(url, githubAPIHeader())
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	var tags []githubTag
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return "", err
+	}
+
+	var latest string
+	var latestVersion []int
+	for _, tag := range tags {
+		v, ok := parseVersion(tag.Name)
+		if ok && (latestVersion == nil || compareVersions(v, latestVersion) > 0) {
+			latest, latestVersion = tag.Name, v
+		}
+	}
+	return latest, nil
+}
+
+func checkUpstreamVersions(d DistroData) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	lagging := map[string]string{}
+
+	sem := make(chan struct{}, *jobs)
+	for pkgname, repodata := range d.Repositories {
+		if len(repodata.Release.Version) == 0 || !strings.Contains(repodata.Source.URL, "github.com") {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(pkgname string, repodata RepoData) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			cleanReleaseVersion(pkgname, &repodata)
+
+			repo, err := getGithubRepoFromURL(repodata.Source.URL)
+			if err != nil {
+				return
+			}
+			tag, err := latestUpstreamTag(repo)
+			if err != nil {
+				warnf("%s: %v", pkgname, err)
+				return
+			}
+
+			released := strings.SplitN(repodata.Release.Version, "-", 2)[0]
+			current, ok := parseVersion(released)
+			latest, _ := parseVersion(tag)
+			if ok && latest != nil && compareVersions(latest, current) > 0 {
+				mu.Lock()
+				lagging[pkgname] = fmt.Sprintf("%s\t%s", released, tag)
+				mu.Unlock()
+			}
+		}(pkgname, repodata)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(lagging))
+	for name := range lagging {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t%s\n", name, lagging[name])
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+)
+
+var voidIndexFile = flag.String("void-index", "", "file listing the available Void packages, one per line; a full run reports dependencies that are neither in it nor generated")
+
+// voidIndex holds the packages named by -void-index.
+var voidIndex map[string]bool
+
+// addVoidDepends records the Void packages sp's template entries name. The
+// caller must hold s.mu.
+func (s *Summary) addVoidDepends(sp *SubPackage) {
+	if s.VoidDepends == nil {
+		s.VoidDepends = map[string][]string{}
+	}
+	deps := withHostBaseline(sp.Name, sp.HostMakeDependencies())
+	deps = append(deps, sp.Pinned(sp.MakeDependencies())...)
+	deps = append(deps, sp.Pinned(sp.RuntimeDependencies())...)
+	for _, dep := range voidDependencyNames(deps) {
+		name, _ := splitVersionConstraint(dep)
+		s.VoidDepends[name] = append(s.VoidDepends[name], sp.Name)
+	}
+}
+
+// checkInstallable adds a diagnostic for every dependency of the written
+// templates that is neither generated in this run nor in -void-index.
+func (s *Summary) checkInstallable() {
+	s.mu.Lock()
+	generated := map[string]bool{}
+	for name := range s.Provided {
+		generated[currentPrefix()+formatPackageName(name)] = true
+	}
+	missing := map[string][]string{}
+	for dep, users := range s.VoidDepends {
+		if generated[dep] || voidIndex[dep] {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, u := range users {
+			if !seen[u] {
+				seen[u] = true
+				missing[dep] = append(missing[dep], u)
+			}
+		}
+		sort.Strings(missing[dep])
+	}
+	s.mu.Unlock()
+
+	deps := make([]string, 0, len(missing))
+	for dep := range missing {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	for _, dep := range deps {
+		s.Diagnostics.Add(dep, diagUninstallable, "needed by %s but neither generated nor in -void-index", strings.Join(missing[dep], " "))
+	}
+}